@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/dns"
+	"github.com/DifuseHQ/dddns/internal/http/dashboard"
+	"github.com/DifuseHQ/dddns/internal/http/handler"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/internal/identity"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/gofiber/fiber/v2"
+	"os"
+)
+
+// adminRoutes registers the admin API and the dashboard on router, which is
+// served both by the main listener and the admin TLS listener.
+func adminRoutes(router fiber.Router, rc *config.Reloadable, guard *identity.Guard, dnsServer *dns.DNSServer) {
+	router.Use("/dashboard", dashboard.Handler())
+
+	admin := router.Group("/admin", middleware.AdminAuthMiddleware(func() string {
+		return rc.Get().AdminToken
+	}))
+
+	audit := middleware.AuditMiddleware()
+
+	admin.Post("/reload-config", audit, handler.ReloadConfig(rc))
+	admin.Get("/devices/:uuid", audit, handler.GetDevice(rc))
+	admin.Put("/devices/:uuid", audit, handler.SetDevice(rc))
+	admin.Get("/config", audit, handler.GetConfig(rc))
+	admin.Get("/records", audit, handler.SearchRecords)
+	admin.Get("/records/:domain/history", audit, handler.GetAdminRecordHistory)
+	admin.Post("/records/bulk", audit, handler.BulkRecords)
+	admin.Delete("/records/:domain", audit, handler.ReleaseRecord)
+	admin.Post("/records/:domain/reassign", audit, handler.ReassignRecord)
+	admin.Get("/audit-log", handler.GetAuditLog)
+	admin.Get("/identity-stats", handler.GetIdentityStats(guard))
+	admin.Get("/stats", handler.GetStats(dnsServer))
+	admin.Get("/health", handler.GetHealth(rc, guard))
+}
+
+// adminTLSConfig returns the TLS config of the admin listener. With a client
+// CA, clients may present a certificate signed by it instead of the admin
+// token.
+func adminTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.AdminTLSCertPath, cfg.AdminTLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading admin TLS certificate: %s", err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.AdminClientCAPath != "" {
+		pem, err := os.ReadFile(cfg.AdminClientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading admin client CA: %s", err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in admin client CA %s", cfg.AdminClientCAPath)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"os"
+)
+
+// configCommand runs "dddns config check", which validates the config given by
+// the same options as the server takes and prints the effective settings, or
+// everything that is wrong with them.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: dddns config check [options]")
+		return 2
+	}
+
+	rc, err := config.Load(flag.NewFlagSet("dddns config check", flag.ContinueOnError), args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(rc.Get().Redacted(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(out))
+
+	return 0
+}
@@ -1,27 +1,94 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"github.com/DifuseHQ/dddns/internal/auth"
 	"github.com/DifuseHQ/dddns/internal/db"
 	"github.com/DifuseHQ/dddns/internal/dns"
 	"github.com/DifuseHQ/dddns/internal/http/handler"
 	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/internal/http/portal"
+	"github.com/DifuseHQ/dddns/internal/identity"
+	"github.com/DifuseHQ/dddns/internal/lifecycle"
 	"github.com/DifuseHQ/dddns/pkg/config"
 	"github.com/DifuseHQ/dddns/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"net"
+	"os"
+	"time"
 )
 
 func main() {
-	cfg := config.InitConfig()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "migrate":
+			os.Exit(migrateCommand(os.Args[2:]))
+		}
+	}
+
+	rc := config.InitConfig()
+	cfg := rc.Get()
 
 	logger.InitLogger(cfg.LogPath, cfg.LogLevel, config.GetVersion())
 	logger.Log.Info("Starting DDDNS")
 
-	db.InitDB(cfg.Domain)
+	var zones []string
+	for _, zone := range cfg.GetZones() {
+		zones = append(zones, zone.Domain)
+	}
+
+	db.InitDB(cfg.DBDSN, cfg.DBPath, cfg.AutoMigrate, zones)
 
 	dnsServer := &dns.DNSServer{}
-	go dnsServer.InitDNSServer(cfg.DNSAddr, cfg.DNSPort, cfg.NameServerDomain, cfg.Domain, cfg.MailBox, cfg.Authoritative, cfg.TunnelARecord, cfg.TunnelAAAARecord)
+
+	if cfg.Cookies {
+		signer, err := dns.NewCookieSigner(cfg.CookieSecret, time.Duration(cfg.CookieRotation)*time.Second)
+		if err != nil {
+			logger.Log.Fatal("Failed to set up DNS cookies ", err)
+		}
+
+		dnsServer.SetCookies(signer)
+	}
+
+	if err := dnsServer.InitDNSServer(cfg); err != nil {
+		logger.Log.Fatal("Failed to configure DNS server ", err)
+	}
+
+	identityGuard := identity.NewGuard()
+
+	verifier := &identity.Swappable{}
+
+	applyIdentity, err := identity.FromConfig(cfg, db.Database, identityGuard, verifier)
+	if err != nil {
+		logger.Log.Fatal("Failed to set up identity verification ", err)
+	}
+	applyIdentity()
+
+	// The identity verifiers are built before the DNS server is configured,
+	// which applies the config when it succeeds, and only swapped in after it
+	// did, so a failing reload leaves both as they were.
+	rc.OnReload(func(old *config.Config, next *config.Config) error {
+		applyIdentity, err := identity.FromConfig(next, db.Database, identityGuard, verifier)
+		if err != nil {
+			return err
+		}
+
+		if err := dnsServer.Configure(next); err != nil {
+			return err
+		}
+
+		applyIdentity()
+		return nil
+	})
+
+	rc.OnReload(func(old *config.Config, next *config.Config) error {
+		logger.SetLevel(next.LogLevel)
+		return nil
+	})
 
 	logger.Log.Info("DNS server initialized")
 
@@ -30,24 +97,126 @@ func main() {
 	})
 
 	app.Get("/", handler.GetDNSStatistics(dnsServer))
+	app.Use("/portal", portal.Handler())
+	app.Get("/nic/update", handler.DynDNSUpdate(rc))
+
+	uuidCheck := middleware.UUIDCheckMiddleware(verifier)
+	device := middleware.DeviceAuthMiddleware(verifier, func() bool {
+		return rc.Get().LegacyUUIDAuth
+	})
+
+	// Credentials are only handed out with a token, so knowing the UUID of a
+	// device isn't enough to get one once it registered.
+	bearer := middleware.DeviceAuthMiddleware(verifier, func() bool {
+		return false
+	})
+
+	signed := middleware.SignedRequestMiddleware(func() time.Duration {
+		return time.Duration(rc.Get().SignatureMaxSkew) * time.Second
+	}, device(auth.ScopeUpdateAddress))
 
 	checks := app.Group("/checks", cors.New(cors.Config{
 		AllowOrigins: "*",
 	}))
 
-	checks.Get("/is-domain-available/:domain", middleware.UUIDCheckMiddleware, handler.IsDomainAvailable(cfg))
-	checks.Get("/is-domain-taken-by-someone/:domain", middleware.UUIDCheckMiddleware, handler.IsDomainTakenByElse(cfg))
+	checks.Get("/is-domain-available/:domain", device(auth.ScopeReadOnly), handler.IsDomainAvailable(rc))
+	checks.Get("/is-domain-taken-by-someone/:domain", device(auth.ScopeReadOnly), handler.IsDomainTakenByElse(rc))
 
 	manageRecords := app.Group("/manage-record", cors.New(cors.Config{
 		AllowOrigins: "*",
 	}))
 
-	manageRecords.Post("/create-or-update", middleware.UUIDCheckMiddleware, handler.CreateRecord(cfg))
-	manageRecords.Delete("/delete", middleware.UUIDCheckMiddleware, handler.DeleteRecord)
+	manageRecords.Get("/list", device(auth.ScopeReadOnly), handler.GetRecords(rc, dnsServer))
+	manageRecords.Post("/create-or-update", signed, handler.CreateRecord(rc))
+	manageRecords.Delete("/delete", device(auth.ScopeUpdateAddress), handler.DeleteRecord)
+	manageRecords.Get("/history", device(auth.ScopeReadOnly), handler.GetRecordHistory)
+	manageRecords.Post("/rollback", device(auth.ScopeUpdateAddress), handler.RollbackRecord(rc))
+	manageRecords.Get("/txt", device(auth.ScopeReadOnly), handler.GetTXTRecords)
+	manageRecords.Put("/txt", device(auth.ScopeManageTXT), handler.SetTXTRecords)
 
-	err := app.Listen(fmt.Sprintf("%s:%s", cfg.HTTPAddr, cfg.HTTPPort))
+	manageDelegations := app.Group("/manage-delegation", cors.New(cors.Config{
+		AllowOrigins: "*",
+	}))
 
-	if err != nil {
-		logger.Log.Fatal("Failed to start HTTP server ", err)
+	manageDelegations.Get("/list", device(auth.ScopeReadOnly), handler.GetDelegations)
+	manageDelegations.Post("/create-or-update", device(auth.ScopeUpdateAddress), handler.SetDelegation(rc))
+	manageDelegations.Delete("/delete", device(auth.ScopeUpdateAddress), handler.DeleteDelegation)
+
+	tokens := app.Group("/auth", cors.New(cors.Config{
+		AllowOrigins: "*",
+	}))
+
+	tokens.Post("/register", uuidCheck, handler.RegisterDevice)
+	tokens.Get("/tokens", device(auth.ScopeReadOnly), handler.GetTokens)
+	tokens.Post("/tokens", bearer(auth.ScopeUpdateAddress), handler.IssueToken)
+	tokens.Post("/tokens/:id/rotate", bearer(auth.ScopeReadOnly), handler.RotateToken)
+	tokens.Delete("/tokens/:id", bearer(auth.ScopeReadOnly), handler.RevokeToken)
+	tokens.Post("/signing-key", bearer(auth.ScopeUpdateAddress), handler.SetSigningKey)
+	tokens.Delete("/signing-key", bearer(auth.ScopeUpdateAddress), handler.DeleteSigningKey)
+
+	tunnel := app.Group("/tunnel", middleware.BearerTokenMiddleware(func() string {
+		return rc.Get().TunnelToken
+	}))
+
+	tunnel.Get("/relays", handler.GetTunnelRelays)
+	tunnel.Post("/relays", handler.UpsertTunnelRelay)
+	tunnel.Delete("/relays/:name", handler.DeleteTunnelRelay)
+	tunnel.Post("/relays/:name/drain", handler.DrainTunnelRelay)
+	tunnel.Get("/endpoints", handler.GetTunnelEndpoints)
+	tunnel.Post("/endpoints", handler.AssignTunnelEndpoint(rc))
+	tunnel.Delete("/endpoints/:domain", handler.DeleteTunnelEndpoint)
+
+	adminRoutes(app, rc, identityGuard, dnsServer)
+
+	manager := lifecycle.NewManager(time.Duration(cfg.ShutdownTimeout) * time.Second)
+
+	manager.AddService(dnsServer)
+	manager.AddService(lifecycle.ServiceFunc{
+		ServiceName: "HTTP server",
+		ServeFunc: func() error {
+			return app.Listen(net.JoinHostPort(cfg.HTTPAddr, cfg.HTTPPort))
+		},
+		ShutdownFunc: app.ShutdownWithContext,
+	})
+
+	if cfg.AdminTLSPort != "" {
+		tlsConfig, err := adminTLSConfig(cfg)
+		if err != nil {
+			logger.Log.Fatal("Failed to set up admin TLS listener ", err)
+		}
+
+		adminApp := fiber.New(fiber.Config{
+			DisableStartupMessage: true,
+		})
+		adminRoutes(adminApp, rc, identityGuard, dnsServer)
+
+		manager.AddService(lifecycle.ServiceFunc{
+			ServiceName: "Admin TLS server",
+			ServeFunc: func() error {
+				ln, err := tls.Listen("tcp", net.JoinHostPort(cfg.HTTPAddr, cfg.AdminTLSPort), tlsConfig)
+				if err != nil {
+					return err
+				}
+
+				return adminApp.Listener(ln)
+			},
+			ShutdownFunc: adminApp.ShutdownWithContext,
+		})
+	}
+
+	manager.OnReload(func() {
+		rc.Reload()
+	})
+
+	manager.AddWorker("dns-cache-pruner", time.Minute, dnsServer.PruneCaches)
+	manager.AddWorker("identity-cache-pruner", time.Minute, identityGuard.Prune)
+	manager.AddWorker("nonce-pruner", time.Minute, db.Database.DeleteExpiredNonces)
+
+	manager.AddCloser("database", db.Close)
+	manager.AddCloser("log", logger.Close)
+
+	if err := manager.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "DDDNS stopped with error:", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"os"
+)
+
+// migrateCommand runs "dddns migrate", which applies the pending database
+// migrations, or only prints their SQL with -dry-run.
+func migrateCommand(args []string) int {
+	fs := flag.NewFlagSet("dddns migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the SQL of pending migrations instead of applying them")
+
+	rc, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg := rc.Get()
+	logger.InitConsoleLogger(cfg.LogLevel)
+
+	names, err := db.Migrate(cfg.DBDSN, cfg.DBPath, *dryRun, os.Stdout)
+
+	for _, name := range names {
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "Pending migration", name)
+		} else {
+			fmt.Fprintln(os.Stderr, "Applied migration", name)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Migration failed:", err)
+		return 1
+	}
+
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "Database schema is up to date")
+	}
+
+	return 0
+}
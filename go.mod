@@ -3,11 +3,15 @@ module github.com/DifuseHQ/dddns
 go 1.21.0
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/go-playground/validator/v10 v10.15.5
 	github.com/gofiber/fiber/v2 v2.50.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/miekg/dns v1.1.56
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/sync v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// GenerateSigningKey returns a new random key for signing requests.
+func GenerateSigningKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// StringToSign is what the signature of a request covers: the method, the
+// path with the query string, the timestamp, the nonce and the SHA-256 of the
+// body, each on a line of its own.
+func StringToSign(method string, target string, timestamp string, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		target,
+		timestamp,
+		nonce,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of stringToSign with key, used as
+// it is given out.
+func Sign(key string, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the signature of
+// stringToSign with key.
+func VerifySignature(key string, stringToSign string, signature string) bool {
+	expected, _ := hex.DecodeString(Sign(key, stringToSign))
+	provided, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(expected, provided)
+}
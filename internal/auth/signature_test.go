@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testBody      = `{"domain":"a.example.com"}`
+	testBodyHash  = "a0d70e516beac4582ce3406639c5c97ca15e1d0775d43cf9ff05c3a43efb618b"
+	testSignature = "1e622ba30c49627c98f30a6858c4a2938e5ab10d0302164a94ac3a34fd49aa9c"
+)
+
+func TestStringToSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		timestamp string
+		nonce     string
+		body      string
+		want      string
+	}{
+		{
+			name:      "post with query",
+			method:    "POST",
+			target:    "/manage-record/create-or-update?x=1",
+			timestamp: "1700000000",
+			nonce:     "nonce-0001",
+			body:      testBody,
+			want:      "POST\n/manage-record/create-or-update?x=1\n1700000000\nnonce-0001\n" + testBodyHash,
+		},
+		{
+			name:      "method upper cased",
+			method:    "post",
+			target:    "/manage-record/create-or-update",
+			timestamp: "1700000000",
+			nonce:     "nonce-0001",
+			body:      testBody,
+			want:      "POST\n/manage-record/create-or-update\n1700000000\nnonce-0001\n" + testBodyHash,
+		},
+		{
+			name:      "empty body",
+			method:    "GET",
+			target:    "/manage-record/list",
+			timestamp: "1700000000",
+			nonce:     "nonce-0002",
+			want:      "GET\n/manage-record/list\n1700000000\nnonce-0002\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StringToSign(tt.method, tt.target, tt.timestamp, tt.nonce, []byte(tt.body))
+			if got != tt.want {
+				t.Errorf("StringToSign() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	stringToSign := StringToSign("POST", "/manage-record/create-or-update?x=1", "1700000000", "nonce-0001", []byte(testBody))
+
+	if got := Sign("secret", stringToSign); got != testSignature {
+		t.Fatalf("Sign() = %q, want %q", got, testSignature)
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		stringToSign string
+		signature    string
+		want         bool
+	}{
+		{name: "valid", key: "secret", stringToSign: stringToSign, signature: testSignature, want: true},
+		{name: "upper case hex", key: "secret", stringToSign: stringToSign, signature: strings.ToUpper(testSignature), want: true},
+		{name: "other key", key: "other", stringToSign: stringToSign, signature: testSignature, want: false},
+		{name: "other path", key: "secret", stringToSign: strings.Replace(stringToSign, "?x=1", "?x=2", 1), signature: testSignature, want: false},
+		{name: "other nonce", key: "secret", stringToSign: strings.Replace(stringToSign, "nonce-0001", "nonce-0002", 1), signature: testSignature, want: false},
+		{name: "truncated", key: "secret", stringToSign: stringToSign, signature: testSignature[:62], want: false},
+		{name: "not hex", key: "secret", stringToSign: stringToSign, signature: "zz" + testSignature[2:], want: false},
+		{name: "empty", key: "secret", stringToSign: stringToSign, signature: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.key, tt.stringToSign, tt.signature); got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSigningKey(t *testing.T) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key) != 64 {
+		t.Errorf("key %q isn't 32 hex encoded bytes", key)
+	}
+
+	other, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == key {
+		t.Error("two generated keys are the same")
+	}
+}
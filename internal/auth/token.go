@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ScopeReadOnly allows reading what the device holds, which every token
+	// can.
+	ScopeReadOnly = "read-only"
+	// ScopeUpdateAddress allows changing the names of the device and their
+	// addresses, delegations and tokens.
+	ScopeUpdateAddress = "update-address"
+	// ScopeManageTXT allows changing the TXT records of the device.
+	ScopeManageTXT = "manage-txt"
+)
+
+const tokenPrefix = "dddns_"
+
+// Scopes are all scopes a token can have.
+var Scopes = []string{ScopeReadOnly, ScopeUpdateAddress, ScopeManageTXT}
+
+// GenerateToken returns a new random token, the prefix it is shown by and the
+// hash it is stored as. The token itself is only known to its holder.
+func GenerateToken() (token string, prefix string, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return token, token[:len(tokenPrefix)+6], HashToken(token), nil
+}
+
+// HashToken returns the hash a token is stored and looked up by. Tokens are
+// random enough that a plain SHA-256 can't be reversed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes checks scopes are known and drops duplicates. A token without
+// scopes is read-only.
+func ParseScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var parsed []string
+
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+
+		if !isScope(scope) {
+			return nil, fmt.Errorf("unknown scope %s", scope)
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+			parsed = append(parsed, scope)
+		}
+	}
+
+	if len(parsed) == 0 {
+		parsed = []string{ScopeReadOnly}
+	}
+
+	return parsed, nil
+}
+
+// HasScope reports whether scopes allow what scope does. Every token can do
+// what read-only ones can.
+func HasScope(scopes []string, scope string) bool {
+	if scope == ScopeReadOnly {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MissingScope returns the first of wanted that scopes don't allow, or ""
+// when they allow all of them. A token can only grant or take over what it
+// can do itself.
+func MissingScope(scopes []string, wanted []string) string {
+	for _, scope := range wanted {
+		if !HasScope(scopes, scope) {
+			return scope
+		}
+	}
+
+	return ""
+}
+
+func isScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
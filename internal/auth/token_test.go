@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateToken(t *testing.T) {
+	token, prefix, hash, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(token, tokenPrefix) {
+		t.Errorf("token %q lacks the %q prefix", token, tokenPrefix)
+	}
+
+	if !strings.HasPrefix(token, prefix) || len(prefix) != len(tokenPrefix)+6 {
+		t.Errorf("prefix %q doesn't start token %q", prefix, token)
+	}
+
+	if hash != HashToken(token) {
+		t.Errorf("hash %q isn't the hash of the token", hash)
+	}
+
+	other, _, _, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == token {
+		t.Error("two generated tokens are the same")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none is read-only", scopes: nil, want: []string{ScopeReadOnly}},
+		{name: "known", scopes: []string{ScopeUpdateAddress, ScopeManageTXT}, want: []string{ScopeUpdateAddress, ScopeManageTXT}},
+		{name: "duplicates dropped", scopes: []string{ScopeManageTXT, " manage-txt "}, want: []string{ScopeManageTXT}},
+		{name: "unknown", scopes: []string{ScopeReadOnly, "admin"}, wantErr: true},
+		{name: "empty", scopes: []string{""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScopes(%q) error = %v, want error %v", tt.scopes, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseScopes(%q) = %q, want %q", tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{name: "read-only always", scopes: nil, scope: ScopeReadOnly, want: true},
+		{name: "held", scopes: []string{ScopeUpdateAddress}, scope: ScopeUpdateAddress, want: true},
+		{name: "not held", scopes: []string{ScopeReadOnly}, scope: ScopeUpdateAddress, want: false},
+		{name: "update-address doesn't imply manage-txt", scopes: []string{ScopeUpdateAddress}, scope: ScopeManageTXT, want: false},
+		{name: "no scopes", scopes: nil, scope: ScopeManageTXT, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasScope(tt.scopes, tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q, %q) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingScope(t *testing.T) {
+	full := []string{ScopeUpdateAddress, ScopeManageTXT}
+
+	tests := []struct {
+		name   string
+		scopes []string
+		wanted []string
+		want   string
+	}{
+		{name: "same scopes", scopes: full, wanted: full, want: ""},
+		{name: "subset", scopes: full, wanted: []string{ScopeManageTXT}, want: ""},
+		{name: "read-only from anything", scopes: []string{ScopeReadOnly}, wanted: []string{ScopeReadOnly}, want: ""},
+		{name: "nothing wanted", scopes: nil, wanted: nil, want: ""},
+		{name: "superset", scopes: []string{ScopeUpdateAddress}, wanted: full, want: ScopeManageTXT},
+		{name: "disjoint", scopes: []string{ScopeManageTXT}, wanted: []string{ScopeUpdateAddress}, want: ScopeUpdateAddress},
+		{name: "read-only wants more", scopes: []string{ScopeReadOnly}, wanted: full, want: ScopeUpdateAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MissingScope(tt.scopes, tt.wanted); got != tt.want {
+				t.Errorf("MissingScope(%q, %q) = %q, want %q", tt.scopes, tt.wanted, got, tt.want)
+			}
+		})
+	}
+}
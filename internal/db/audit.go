@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+)
+
+// InsertAuditEntry appends a request to the admin API to the audit log.
+func (s *sqlStore) InsertAuditEntry(entry *model.AuditEntry) error {
+	details := sql.NullString{String: string(entry.Details), Valid: len(entry.Details) > 0}
+
+	_, err := s.exec(`INSERT INTO admin_audit_log (actor, method, path, status, details, source_ip, created_at) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		entry.Actor, entry.Method, entry.Path, entry.Status, details, entry.SourceIP)
+	if err != nil {
+		logger.Log.Error("Error inserting audit entry ", err.Error())
+		return fmt.Errorf("error inserting audit entry")
+	}
+
+	return nil
+}
+
+// GetAuditLog returns a page of the audit log, newest first, and the number
+// of entries in it.
+func (s *sqlStore) GetAuditLog(offset int, limit int) ([]model.AuditEntry, int, error) {
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM admin_audit_log`).Scan(&total); err != nil {
+		logger.Log.Error("Error counting audit entries ", err.Error())
+		return nil, 0, fmt.Errorf("error querying audit log")
+	}
+
+	rows, err := s.query(`SELECT id, actor, method, path, status, details, source_ip, created_at FROM admin_audit_log ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		logger.Log.Error("Error querying audit log ", err.Error())
+		return nil, 0, fmt.Errorf("error querying audit log")
+	}
+	defer rows.Close()
+
+	entries := []model.AuditEntry{}
+	for rows.Next() {
+		var entry model.AuditEntry
+		var details sql.NullString
+
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Method, &entry.Path, &entry.Status, &details, &entry.SourceIP, &entry.CreatedAt); err != nil {
+			logger.Log.Error("Error scanning audit entry ", err.Error())
+			return nil, 0, fmt.Errorf("error querying audit log")
+		}
+
+		if details.Valid {
+			entry.Details = []byte(details.String)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, total, rows.Err()
+}
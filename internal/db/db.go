@@ -2,105 +2,736 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/DifuseHQ/dddns/internal/db/model"
 	"github.com/DifuseHQ/dddns/pkg/logger"
-	_ "github.com/mattn/go-sqlite3"
-	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
-var Database *sql.DB
+var Database Store
 
-func InitDB(domain string) {
-	var err error
+// ErrDomainTaken is returned when storing a record under a name another
+// device holds.
+var ErrDomainTaken = errors.New("domain is taken by another device")
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		logger.Log.Fatal("Error getting current working directory ", err.Error())
-	}
+// ErrQuotaExceeded is returned when a device adding a name already holds as
+// many names as it may.
+var ErrQuotaExceeded = errors.New("device holds the maximum number of names")
 
-	dbPath := fmt.Sprintf("%s/data/dddns.db", cwd)
-
-	Database, err = sql.Open("sqlite3", dbPath)
+// InitDB opens the store given by dsn, or the SQLite database at path when
+// dsn is empty, and applies pending migrations when autoMigrate is set. The
+// first of zones is the primary zone, which holds the loopback record.
+func InitDB(dsn string, path string, autoMigrate bool, zones []string) {
+	store, err := open(dsn, path)
 	if err != nil {
 		logger.Log.Fatal("Error opening database connection ", err.Error())
 	}
 
-	createTablesSQL := `
-	CREATE TABLE IF NOT EXISTS records (
-		"uuid" TEXT NOT NULL PRIMARY KEY,
-		"domain" TEXT,
-		"a_record" TEXT,
-		"aaaa_record" TEXT,
-		"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP,
-		"last_update_at" DATETIME DEFAULT CURRENT_TIMESTAMP
-    );
+	if autoMigrate {
+		applied, err := store.migrate()
+		for _, name := range applied {
+			logger.Log.Info("Applied database migration ", name)
+		}
 
-	CREATE INDEX IF NOT EXISTS idx_records_domain ON records (domain);
+		if err != nil {
+			logger.Log.Fatal("Error migrating database ", err.Error())
+		}
+	} else {
+		pending, err := store.pendingMigrations()
+		if err != nil {
+			logger.Log.Fatal("Error checking database migrations ", err.Error())
+		}
 
-	PRAGMA journal_mode=WAL;
-    `
+		if len(pending) > 0 {
+			logger.Log.Fatal("Database schema is out of date, ", len(pending), " migrations pending. Run dddns migrate")
+		}
+	}
 
-	_, err = Database.Exec(createTablesSQL)
+	for _, zone := range zones {
+		_, err = store.exec(`UPDATE records SET zone = ? WHERE zone IS NULL AND (domain = ? OR domain LIKE ?)`, zone, zone, "%."+zone)
+		if err != nil {
+			logger.Log.Fatal("Error assigning records to zone ", zone, " ", err.Error())
+		}
 
-	if err != nil {
-		logger.Log.Fatal("Error creating records table", err.Error())
+		_, err = store.exec(`INSERT INTO zone_serials (zone, serial) VALUES (?, ?) ON CONFLICT(zone) DO NOTHING`, zone, baseSerial())
+		if err != nil {
+			logger.Log.Fatal("Error initializing serial of zone ", zone, " ", err.Error())
+		}
 	}
 
+	domain := zones[0]
 	loopbackDomain := "loopback." + domain
 
 	logger.Log.Debug(fmt.Sprintf("Inserting loopback record %s", loopbackDomain))
 
-	_, err = InsertOrUpdateRecord(Database, &model.Record{
+	_, err = store.InsertOrUpdateRecord(&model.Record{
 		UUID:       "a10af838-c870-4291-a81f-ee09098d7247",
 		Domain:     "loopback." + domain,
 		ARecord:    "127.0.0.1",
 		AAAARecord: "::1",
-	}, domain)
+	}, domain, 0, model.Change{Actor: "system"})
 
 	if err != nil {
 		logger.Log.Fatal("Error inserting initial record", err.Error())
 	}
 
-	logger.Log.Info("Database initialized")
+	Database = store
+
+	logger.Log.Info("Database initialized (", store.dialect.name, ")")
 }
 
-func InsertOrUpdateRecord(database *sql.DB, record *model.Record, domain string) (bool, error) {
-	if !strings.HasSuffix(record.Domain, domain) {
+// InsertOrUpdateRecord stores a name of a device in the zone domain, along
+// with its view overrides unless record.Views is nil, and journals the change
+// in the record history. It returns ErrDomainTaken when
+// another device holds the name, and ErrQuotaExceeded when the name is new and
+// the device already holds quota names, 0 meaning no limit. A device with a
+// quota of one renames its name instead, like before devices could hold
+// several.
+func (s *sqlStore) InsertOrUpdateRecord(record *model.Record, domain string, quota int, change model.Change) (bool, error) {
+	if !strings.HasSuffix(record.Domain, "."+domain) {
 		return false, fmt.Errorf("record domain requested %s doesn't include domain %s", record.Domain, domain)
 	}
 
+	record.Zone = domain
+
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+	defer tx.Rollback()
+
+	// Writing the device first locks its row on PostgreSQL, so concurrent
+	// requests of the device count its names one after another. SQLite
+	// transactions hold the database write lock from the start instead.
+	_, err = tx.Exec(`INSERT INTO devices (uuid) VALUES (?) ON CONFLICT(uuid) DO UPDATE SET uuid = excluded.uuid`, record.UUID)
+	if err != nil {
+		logger.Log.Error("Error inserting device ", err.Error())
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+
+	old, err := getRecord(tx.QueryRow, `domain = ?`, record.Domain)
+	if err != nil {
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+
+	if old != nil && old.UUID != record.UUID {
+		return false, ErrDomainTaken
+	}
+
+	if old != nil {
+		if old.Views, err = getRecordViews(tx.Query, old.Domain); err != nil {
+			return false, fmt.Errorf("error inserting or updating record")
+		}
+	}
+
+	if old == nil && quota > 0 {
+		held, err := queryRecords(tx.Query, `uuid = ?`, record.UUID)
+		if err != nil {
+			return false, fmt.Errorf("error inserting or updating record")
+		}
+
+		if len(held) >= quota {
+			if quota > 1 || len(held) > 1 {
+				return false, ErrQuotaExceeded
+			}
+
+			old = &held[0]
+			if old.Views, err = getRecordViews(tx.Query, old.Domain); err != nil {
+				return false, fmt.Errorf("error inserting or updating record")
+			}
+
+			if _, err := tx.Exec(`DELETE FROM records WHERE domain = ?`, old.Domain); err != nil {
+				logger.Log.Error("Error deleting renamed record ", err.Error())
+				return false, fmt.Errorf("error inserting or updating record")
+			}
+		}
+	}
+
+	// The update only applies to names of the same device, in case another
+	// device claimed the name since it was checked above.
 	upsertSQL := `
-	INSERT INTO records (uuid, domain, a_record, aaaa_record, last_update_at)
-	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
-	ON CONFLICT(uuid) DO UPDATE SET 
+	INSERT INTO records (domain, uuid, zone, a_record, aaaa_record, alias, last_update_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(domain) DO UPDATE SET 
 		a_record = excluded.a_record, 
-		domain = excluded.domain,
+		zone = excluded.zone,
 		aaaa_record = excluded.aaaa_record, 
-		last_update_at = CURRENT_TIMESTAMP;
+		alias = excluded.alias,
+		last_update_at = CURRENT_TIMESTAMP
+	WHERE records.uuid = excluded.uuid;
 	`
 
-	_, err := database.Exec(upsertSQL, record.UUID, record.Domain, record.ARecord, record.AAAARecord)
+	result, err := tx.Exec(upsertSQL, record.Domain, record.UUID, record.Zone, record.ARecord, record.AAAARecord, record.Alias)
 	if err != nil {
+		if s.dialect.uniqueViolation(err) {
+			return false, ErrDomainTaken
+		}
+
 		logger.Log.Error("Error inserting or updating record ", err.Error())
 		return false, fmt.Errorf("error inserting or updating record")
 	}
 
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return false, ErrDomainTaken
+	}
+
+	switch {
+	case record.Views != nil:
+		for i := range record.Views {
+			record.Views[i].Domain = record.Domain
+		}
+
+		sort.Slice(record.Views, func(i, j int) bool {
+			return record.Views[i].View < record.Views[j].View
+		})
+
+		if err := setRecordViews(tx, record.Domain, record.Views); err != nil {
+			return false, fmt.Errorf("error inserting or updating record")
+		}
+	case old != nil && old.Domain == record.Domain:
+		record.Views = old.Views
+	default:
+		record.Views = []model.RecordView{}
+	}
+
+	changed := []string{record.Domain}
+	if old != nil && old.Domain != record.Domain {
+		changed = append(changed, old.Domain)
+	}
+
+	if err := deleteOrphanedRecordData(tx, changed...); err != nil {
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+
+	if err := insertHistory(tx, record.UUID, old, record, change); err != nil {
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing record ", err.Error())
+		return false, fmt.Errorf("error inserting or updating record")
+	}
+
+	if old != nil && old.Zone != record.Zone {
+		s.bumpSerial(old.Zone)
+	}
+	s.bumpSerial(record.Zone)
+
 	logger.Log.Debug("Record inserted or updated ", record)
 	return true, nil
 }
 
-func DeleteRecord(database *sql.DB, uuid string) (bool, error) {
-	deleteSQL := `DELETE FROM records WHERE uuid = ?;`
+// GetRecords returns the names held by a device, sorted by name.
+func (s *sqlStore) GetRecords(uuid string) ([]model.Record, error) {
+	return queryRecords(s.query, `uuid = ?`, uuid)
+}
+
+// GetRecordByDomain returns the record holding a name, without the trailing
+// dot.
+func (s *sqlStore) GetRecordByDomain(domain string) (*model.Record, error) {
+	return getRecord(s.queryRow, `domain = ?`, domain)
+}
+
+// holderBatchSize is how many names GetRecordHolders looks up per query,
+// below the bound parameter limit of SQLite.
+const holderBatchSize = 500
+
+// GetRecordHolders returns the device holding each of domains that is held,
+// by name.
+func (s *sqlStore) GetRecordHolders(domains []string) (map[string]string, error) {
+	holders := make(map[string]string, len(domains))
+
+	for start := 0; start < len(domains); start += holderBatchSize {
+		batch := domains[start:min(start+holderBatchSize, len(domains))]
+
+		args := make([]interface{}, len(batch))
+		for i, domain := range batch {
+			args[i] = domain
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+
+		rows, err := s.query(`SELECT domain, uuid FROM records WHERE domain IN (`+placeholders+`)`, args...)
+		if err != nil {
+			logger.Log.Error("Error querying record holders ", err.Error())
+			return nil, fmt.Errorf("error querying record holders")
+		}
 
-	_, err := database.Exec(deleteSQL, uuid)
+		for rows.Next() {
+			var domain, uuid string
+			if err := rows.Scan(&domain, &uuid); err != nil {
+				rows.Close()
+				logger.Log.Error("Error scanning record holder ", err.Error())
+				return nil, fmt.Errorf("error querying record holders")
+			}
+			holders[domain] = uuid
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			logger.Log.Error("Error querying record holders ", err.Error())
+			return nil, fmt.Errorf("error querying record holders")
+		}
+	}
+
+	return holders, nil
+}
+
+// IsDomainTaken reports whether a device other than the one of uuid holds a
+// name. With an empty uuid, it reports whether any device holds it.
+func (s *sqlStore) IsDomainTaken(domain string, uuid string) (bool, error) {
+	return domainTaken(s.queryRow, domain, uuid)
+}
+
+func domainTaken(queryRow func(string, ...interface{}) *sql.Row, domain string, uuid string) (bool, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	holder, err := getRecord(queryRow, `domain = ?`, domain)
 	if err != nil {
-		logger.Log.Error("Error deleting record ", err.Error())
 		return false, err
 	}
 
-	logger.Log.Debug("Record deleted for ", uuid)
+	return holder != nil && holder.UUID != uuid, nil
+}
+
+const recordColumns = `uuid, domain, COALESCE(zone, ''), COALESCE(a_record, ''), COALESCE(aaaa_record, ''), COALESCE(alias, ''), created_at, last_update_at`
 
-	return true, nil
+// getRecord returns the record matching where, read with queryRow of either
+// the store or a transaction.
+func getRecord(queryRow func(string, ...interface{}) *sql.Row, where string, args ...interface{}) (*model.Record, error) {
+	query := `SELECT ` + recordColumns + ` FROM records WHERE ` + where
+
+	record := &model.Record{}
+	err := queryRow(query, args...).Scan(&record.UUID, &record.Domain, &record.Zone, &record.ARecord, &record.AAAARecord, &record.Alias, &record.CreatedAt, &record.LastUpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying record ", err.Error())
+		return nil, fmt.Errorf("error querying record")
+	}
+
+	return record, nil
+}
+
+// queryRecords returns the records matching where, sorted by name.
+func queryRecords(query func(string, ...interface{}) (*sql.Rows, error), where string, args ...interface{}) ([]model.Record, error) {
+	return selectRecords(query, where+` ORDER BY domain`, args...)
+}
+
+// selectRecords returns the records of a query ending in clause, which
+// follows the WHERE keyword.
+func selectRecords(query func(string, ...interface{}) (*sql.Rows, error), clause string, args ...interface{}) ([]model.Record, error) {
+	rows, err := query(`SELECT `+recordColumns+` FROM records WHERE `+clause, args...)
+	if err != nil {
+		logger.Log.Error("Error querying records ", err.Error())
+		return nil, fmt.Errorf("error querying records")
+	}
+	defer rows.Close()
+
+	records := []model.Record{}
+	for rows.Next() {
+		var record model.Record
+		if err := rows.Scan(&record.UUID, &record.Domain, &record.Zone, &record.ARecord, &record.AAAARecord, &record.Alias, &record.CreatedAt, &record.LastUpdateAt); err != nil {
+			logger.Log.Error("Error scanning record ", err.Error())
+			return nil, fmt.Errorf("error querying records")
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// DeleteRecord deletes a name of a device, or all of its names when domain is
+// empty, and journals the deletions in the record history. It reports whether
+// anything was deleted.
+func (s *sqlStore) DeleteRecord(uuid string, domain string, change model.Change) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return false, fmt.Errorf("error deleting record")
+	}
+	defer tx.Rollback()
+
+	var records []model.Record
+	if domain == "" {
+		records, err = queryRecords(tx.Query, `uuid = ?`, uuid)
+	} else {
+		records, err = queryRecords(tx.Query, `uuid = ? AND domain = ?`, uuid, domain)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for i := range records {
+		if records[i].Views, err = getRecordViews(tx.Query, records[i].Domain); err != nil {
+			return false, err
+		}
+
+		_, err = tx.Exec(`DELETE FROM records WHERE domain = ?;`, records[i].Domain)
+		if err != nil {
+			logger.Log.Error("Error deleting record ", err.Error())
+			return false, err
+		}
+
+		if err := deleteOrphanedRecordData(tx, records[i].Domain); err != nil {
+			return false, err
+		}
+
+		if err := insertHistory(tx, uuid, &records[i], nil, change); err != nil {
+			return false, fmt.Errorf("error deleting record")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing record deletion ", err.Error())
+		return false, fmt.Errorf("error deleting record")
+	}
+
+	for _, record := range records {
+		s.bumpSerial(record.Zone)
+	}
+
+	logger.Log.Debug("Records deleted for ", uuid, ": ", len(records))
+
+	return len(records) > 0, nil
+}
+
+// SearchRecords returns a page of the records matching filter, sorted by
+// name, and the number of records matching it.
+func (s *sqlStore) SearchRecords(filter model.RecordFilter) ([]model.Record, int, error) {
+	where := `1 = 1`
+	args := []interface{}{}
+
+	if filter.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Query)) + "%"
+		where += ` AND (domain LIKE ? ESCAPE '\' OR uuid LIKE ? ESCAPE '\' OR a_record LIKE ? ESCAPE '\' OR aaaa_record LIKE ? ESCAPE '\' OR alias LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
+	}
+
+	if filter.UUID != "" {
+		where += ` AND uuid = ?`
+		args = append(args, filter.UUID)
+	}
+
+	if filter.Zone != "" {
+		where += ` AND zone = ?`
+		args = append(args, filter.Zone)
+	}
+
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM records WHERE `+where, args...).Scan(&total); err != nil {
+		logger.Log.Error("Error counting records ", err.Error())
+		return nil, 0, fmt.Errorf("error querying records")
+	}
+
+	records, err := selectRecords(s.query, where+` ORDER BY domain LIMIT ? OFFSET ?`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ReassignRecord moves a name to another device, regardless of the quota of
+// that device, and journals it as leaving one device and joining the other.
+// View overrides, TXT records and delegations of the name are dropped like
+// when it is released. It returns the moved record, or nil when no device holds the name.
+func (s *sqlStore) ReassignRecord(domain string, uuid string, change model.Change) (*model.Record, error) {
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO devices (uuid) VALUES (?) ON CONFLICT(uuid) DO UPDATE SET uuid = excluded.uuid`, uuid)
+	if err != nil {
+		logger.Log.Error("Error inserting device ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	old, err := getRecord(tx.QueryRow, `domain = ?`, domain)
+	if err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if old == nil {
+		return nil, nil
+	}
+
+	if old.UUID == uuid {
+		return old, nil
+	}
+
+	if old.Views, err = getRecordViews(tx.Query, domain); err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if _, err := tx.Exec(`UPDATE records SET uuid = ?, last_update_at = CURRENT_TIMESTAMP WHERE domain = ?`, uuid, domain); err != nil {
+		logger.Log.Error("Error reassigning record ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM record_views WHERE domain = ?`, domain); err != nil {
+		logger.Log.Error("Error deleting record views ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM txt_records WHERE domain = ?`, domain); err != nil {
+		logger.Log.Error("Error deleting TXT records ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if err := deleteOrphanedRecordData(tx, domain); err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	record, err := getRecord(tx.QueryRow, `domain = ?`, domain)
+	if err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+	record.Views = []model.RecordView{}
+
+	if err := insertHistory(tx, old.UUID, old, nil, change); err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if err := insertHistory(tx, uuid, nil, record, change); err != nil {
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing record reassignment ", err.Error())
+		return nil, fmt.Errorf("error reassigning record")
+	}
+
+	// Views and TXT records of the name are gone, so its answers may have
+	// changed.
+	s.bumpSerial(record.Zone)
+
+	logger.Log.Debug("Record reassigned ", domain, " from ", old.UUID, " to ", uuid)
+
+	return record, nil
+}
+
+// serialCacheTTL is how long a zone serial read from the database is served
+// from memory. Changes through this node drop it right away, so the TTL only
+// bounds how long changes by other nodes sharing the database take to show.
+const serialCacheTTL = 5 * time.Second
+
+// serialCache keeps the serials of zones, as every negative answer carries
+// the SOA record of its zone.
+type serialCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedSerial
+	// generation counts invalidations, so a read overlapping a bump doesn't
+	// cache the serial from before it
+	generation uint64
+}
+
+type cachedSerial struct {
+	serial  uint32
+	expires time.Time
+}
+
+func (c *serialCache) get(zone string) (uint32, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[zone]
+	if !ok || time.Now().After(entry.expires) {
+		return 0, c.generation, false
+	}
+
+	return entry.serial, c.generation, true
+}
+
+func (c *serialCache) put(zone string, serial uint32, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]cachedSerial)
+	}
+
+	c.entries[zone] = cachedSerial{serial: serial, expires: time.Now().Add(serialCacheTTL)}
+}
+
+func (c *serialCache) invalidate(zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, zone)
+	c.generation++
+}
+
+// ZoneSerial returns the SOA serial of a zone, which changes whenever a name
+// in it does.
+func (s *sqlStore) ZoneSerial(zone string) (uint32, error) {
+	cached, generation, ok := s.serials.get(zone)
+	if ok {
+		return cached, nil
+	}
+
+	var serial int64
+
+	err := s.queryRow(`SELECT serial FROM zone_serials WHERE zone = ?`, zone).Scan(&serial)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return baseSerial(), nil
+		}
+
+		logger.Log.Error("Error querying zone serial ", err.Error())
+		return 0, fmt.Errorf("error querying zone serial")
+	}
+
+	s.serials.put(zone, uint32(serial), generation)
+
+	return uint32(serial), nil
+}
+
+// bumpSerial increments the serial of a zone, starting over at the first
+// serial of the day when that is higher.
+func (s *sqlStore) bumpSerial(zone string) {
+	if zone == "" {
+		return
+	}
+
+	defer s.serials.invalidate(zone)
+
+	_, err := s.exec(`
+	INSERT INTO zone_serials (zone, serial) VALUES (?, ?)
+	ON CONFLICT(zone) DO UPDATE SET serial = CASE
+		WHEN zone_serials.serial >= excluded.serial THEN zone_serials.serial + 1
+		ELSE excluded.serial
+	END`, zone, baseSerial())
+	if err != nil {
+		logger.Log.Warn("Error updating serial of zone ", zone, " ", err.Error())
+	}
+}
+
+// zoneOf returns the zone of the record a name lies at or below.
+func (s *sqlStore) zoneOf(name string) string {
+	var zone string
+
+	placeholders, args := enclosingNames(name)
+	err := s.queryRow(`SELECT COALESCE(zone, '') FROM records WHERE domain IN (`+placeholders+`) ORDER BY LENGTH(domain) DESC LIMIT 1`, args...).Scan(&zone)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Log.Warn("Error querying zone of ", name, " ", err.Error())
+	}
+
+	return zone
+}
+
+// enclosingNames returns the placeholders and arguments of an IN list of name
+// and every name it lies below but the top-level domain, so names above one
+// are looked up by index instead of matching every row against a pattern.
+func enclosingNames(name string) (string, []interface{}) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+
+	placeholders := []string{"?"}
+	args := []interface{}{name}
+
+	for i := 1; i < len(labels)-1; i++ {
+		placeholders = append(placeholders, "?")
+		args = append(args, strings.Join(labels[i:], "."))
+	}
+
+	return strings.Join(placeholders, ", "), args
+}
+
+// deleteOrphanedRecordData removes view overrides, TXT records and
+// delegations left without their name by a change of domains, so a released
+// name doesn't carry them over to its next owner. Only rows of the changed
+// names are looked at, keeping updates cheap however many names are stored.
+func deleteOrphanedRecordData(tx *sqlTx, domains ...string) error {
+	for _, domain := range domains {
+		_, err := tx.Exec(`DELETE FROM record_views WHERE domain = ? AND NOT EXISTS (SELECT 1 FROM records WHERE domain = ?)`, domain, domain)
+		if err != nil {
+			logger.Log.Error("Error deleting orphaned record views ", err.Error())
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM txt_records WHERE domain = ? AND NOT EXISTS (SELECT 1 FROM records WHERE domain = ?)`, domain, domain)
+		if err != nil {
+			logger.Log.Error("Error deleting orphaned TXT records ", err.Error())
+			return err
+		}
+
+		if err := deleteOrphanedDelegations(tx, domain); err != nil {
+			logger.Log.Error("Error deleting orphaned delegations ", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteOrphanedDelegations removes the delegations below domain whose device
+// no longer holds a name above them.
+func deleteOrphanedDelegations(tx *sqlTx, domain string) error {
+	rows, err := tx.Query(`SELECT DISTINCT domain, uuid FROM delegations WHERE domain LIKE ? ESCAPE '\'`, "%."+likeEscaper.Replace(domain))
+	if err != nil {
+		return err
+	}
+
+	var delegations []model.Delegation
+	for rows.Next() {
+		var delegation model.Delegation
+		if err := rows.Scan(&delegation.Domain, &delegation.UUID); err != nil {
+			rows.Close()
+			return err
+		}
+		delegations = append(delegations, delegation)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, delegation := range delegations {
+		_, parent, _ := strings.Cut(delegation.Domain, ".")
+		placeholders, args := enclosingNames(parent)
+
+		var held int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM records WHERE uuid = ? AND domain IN (`+placeholders+`)`, append([]interface{}{delegation.UUID}, args...)...).Scan(&held)
+		if err != nil {
+			return err
+		}
+
+		if held > 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(`DELETE FROM delegations WHERE domain = ? AND uuid = ?`, delegation.Domain, delegation.UUID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the store, for SQLite after checkpointing the write-ahead log
+// into the database file.
+func Close() error {
+	if Database == nil {
+		return nil
+	}
+
+	return Database.Close()
 }
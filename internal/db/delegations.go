@@ -0,0 +1,146 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"strings"
+)
+
+// SetDelegation replaces the nameservers of a delegated domain. The domain has
+// to lie below a name held by the device.
+func (s *sqlStore) SetDelegation(delegation *model.Delegation) (bool, error) {
+	_, parent, _ := strings.Cut(delegation.Domain, ".")
+	placeholders, args := enclosingNames(parent)
+
+	record, err := getRecord(s.queryRow, `uuid = ? AND domain IN (`+placeholders+`) LIMIT 1`, append([]interface{}{delegation.UUID}, args...)...)
+	if err != nil {
+		return false, err
+	}
+
+	if record == nil {
+		return false, fmt.Errorf("delegated domain %s isn't below a domain owned by the device", delegation.Domain)
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return false, fmt.Errorf("error setting delegation")
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRow(`SELECT uuid FROM delegations WHERE domain = ? LIMIT 1`, delegation.Domain).Scan(&owner)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Log.Error("Error querying delegation owner ", err.Error())
+		return false, fmt.Errorf("error setting delegation")
+	}
+
+	if owner != "" && owner != delegation.UUID {
+		return false, fmt.Errorf("domain %s is already delegated by another device", delegation.Domain)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM delegations WHERE domain = ?`, delegation.Domain); err != nil {
+		logger.Log.Error("Error deleting delegation ", err.Error())
+		return false, fmt.Errorf("error setting delegation")
+	}
+
+	for _, server := range delegation.NameServers {
+		_, err := tx.Exec(`INSERT INTO delegations (domain, nameserver, uuid, a_record, aaaa_record) VALUES (?, ?, ?, ?, ?)`,
+			delegation.Domain, server.Name, delegation.UUID, server.ARecord, server.AAAARecord)
+		if err != nil {
+			logger.Log.Error("Error inserting delegation ", err.Error())
+			return false, fmt.Errorf("error setting delegation")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing delegation ", err.Error())
+		return false, fmt.Errorf("error setting delegation")
+	}
+
+	s.bumpSerial(record.Zone)
+
+	logger.Log.Debug("Delegation set for ", delegation.Domain)
+
+	return true, nil
+}
+
+// GetDelegations returns the delegations of a device.
+func (s *sqlStore) GetDelegations(uuid string) ([]model.Delegation, error) {
+	return s.queryDelegations(`WHERE uuid = ?`, uuid)
+}
+
+// FindDelegation returns the closest delegation at or above name, or nil.
+func (s *sqlStore) FindDelegation(name string) (*model.Delegation, error) {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.Contains(name, ".") {
+		return nil, nil
+	}
+
+	placeholders, args := enclosingNames(name)
+
+	delegations, err := s.queryDelegations(`WHERE domain IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var closest *model.Delegation
+	for i := range delegations {
+		if closest == nil || len(delegations[i].Domain) > len(closest.Domain) {
+			closest = &delegations[i]
+		}
+	}
+
+	return closest, nil
+}
+
+func (s *sqlStore) DeleteDelegation(domain string, uuid string) (bool, error) {
+	result, err := s.exec(`DELETE FROM delegations WHERE domain = ? AND uuid = ?`, domain, uuid)
+	if err != nil {
+		logger.Log.Error("Error deleting delegation ", err.Error())
+		return false, fmt.Errorf("error deleting delegation")
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected > 0 {
+		s.bumpSerial(s.zoneOf(domain))
+	}
+
+	logger.Log.Debug("Delegation deleted ", domain)
+
+	return affected > 0, nil
+}
+
+func (s *sqlStore) queryDelegations(where string, args ...interface{}) ([]model.Delegation, error) {
+	query := `SELECT domain, uuid, nameserver, a_record, aaaa_record, created_at FROM delegations ` + where + ` ORDER BY domain, nameserver`
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		logger.Log.Error("Error querying delegations ", err.Error())
+		return nil, fmt.Errorf("error querying delegations")
+	}
+	defer rows.Close()
+
+	delegations := []model.Delegation{}
+	for rows.Next() {
+		var delegation model.Delegation
+		var server model.DelegationServer
+
+		if err := rows.Scan(&delegation.Domain, &delegation.UUID, &server.Name, &server.ARecord, &server.AAAARecord, &delegation.CreatedAt); err != nil {
+			logger.Log.Error("Error scanning delegation ", err.Error())
+			return nil, fmt.Errorf("error querying delegations")
+		}
+
+		if n := len(delegations); n > 0 && delegations[n-1].Domain == delegation.Domain {
+			delegations[n-1].NameServers = append(delegations[n-1].NameServers, server)
+			continue
+		}
+
+		delegation.NameServers = []model.DelegationServer{server}
+		delegations = append(delegations, delegation)
+	}
+
+	return delegations, rows.Err()
+}
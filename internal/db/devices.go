@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+)
+
+// GetDevice returns a device, or nil when it never held a name and wasn't
+// set up otherwise.
+func (s *sqlStore) GetDevice(uuid string) (*model.Device, error) {
+	device := &model.Device{}
+	var maxNames sql.NullInt64
+
+	err := s.queryRow(`SELECT uuid, plan, max_names, created_at FROM devices WHERE uuid = ?`, uuid).Scan(&device.UUID, &device.Plan, &maxNames, &device.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying device ", err.Error())
+		return nil, fmt.Errorf("error querying device")
+	}
+
+	if maxNames.Valid {
+		n := int(maxNames.Int64)
+		device.MaxNames = &n
+	}
+
+	return device, nil
+}
+
+// SetDevice creates a device or updates its plan and name quota.
+func (s *sqlStore) SetDevice(device *model.Device) (bool, error) {
+	var maxNames sql.NullInt64
+	if device.MaxNames != nil {
+		maxNames = sql.NullInt64{Int64: int64(*device.MaxNames), Valid: true}
+	}
+
+	_, err := s.exec(`
+	INSERT INTO devices (uuid, plan, max_names) VALUES (?, ?, ?)
+	ON CONFLICT(uuid) DO UPDATE SET
+		plan = excluded.plan,
+		max_names = excluded.max_names`, device.UUID, device.Plan, maxNames)
+	if err != nil {
+		logger.Log.Error("Error setting device ", err.Error())
+		return false, fmt.Errorf("error setting device")
+	}
+
+	logger.Log.Debug("Device set ", device.UUID)
+
+	return true, nil
+}
+
+// GetSigningKey returns the key the device signs requests with, or an empty
+// string when it has none.
+func (s *sqlStore) GetSigningKey(uuid string) (string, error) {
+	var key sql.NullString
+
+	err := s.queryRow(`SELECT signing_key FROM devices WHERE uuid = ?`, uuid).Scan(&key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+
+		logger.Log.Error("Error querying signing key ", err.Error())
+		return "", fmt.Errorf("error querying signing key")
+	}
+
+	return key.String, nil
+}
+
+// SetSigningKey sets the key the device signs requests with, creating the
+// device if needed. An empty key removes it.
+func (s *sqlStore) SetSigningKey(uuid string, key string) (bool, error) {
+	signingKey := sql.NullString{String: key, Valid: key != ""}
+
+	_, err := s.exec(`
+	INSERT INTO devices (uuid, signing_key) VALUES (?, ?)
+	ON CONFLICT(uuid) DO UPDATE SET signing_key = excluded.signing_key`, uuid, signingKey)
+	if err != nil {
+		logger.Log.Error("Error setting signing key ", err.Error())
+		return false, fmt.Errorf("error setting signing key")
+	}
+
+	logger.Log.Debug("Signing key set ", uuid)
+
+	return true, nil
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+)
+
+// insertHistory journals the change of a record from old to next, either nil
+// when the record didn't exist before or doesn't anymore. Nothing is
+// journaled when the values stay the same.
+func insertHistory(tx *sqlTx, uuid string, old *model.Record, next *model.Record, change model.Change) error {
+	oldValue := recordValue(old)
+	newValue := recordValue(next)
+
+	if oldValue == nil && newValue == nil {
+		return nil
+	}
+
+	if oldValue != nil && newValue != nil && sameRecordValue(oldValue, newValue) && change.Action == "" {
+		return nil
+	}
+
+	action := change.Action
+	if action == "" {
+		switch {
+		case oldValue == nil:
+			action = "create"
+		case newValue == nil:
+			action = "delete"
+		default:
+			action = "update"
+		}
+	}
+
+	domain := ""
+	if newValue != nil {
+		domain = newValue.Domain
+	} else {
+		domain = oldValue.Domain
+	}
+
+	oldJSON, err := marshalRecordValue(oldValue)
+	if err != nil {
+		return err
+	}
+
+	newJSON, err := marshalRecordValue(newValue)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO record_history (uuid, domain, action, old_value, new_value, actor, source_ip, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		uuid, domain, action, oldJSON, newJSON, change.Actor, change.SourceIP)
+	if err != nil {
+		logger.Log.Error("Error inserting record history ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetRecordHistory returns the latest changes of the records of a device, or
+// of one of its names when domain is set, newest first. With an empty uuid,
+// it returns the changes of all devices.
+func (s *sqlStore) GetRecordHistory(uuid string, domain string, limit int) ([]model.RecordHistory, error) {
+	query := `SELECT id, uuid, domain, action, old_value, new_value, actor, source_ip, created_at FROM record_history WHERE 1 = 1`
+	args := []interface{}{}
+
+	if uuid != "" {
+		query += ` AND uuid = ?`
+		args = append(args, uuid)
+	}
+
+	if domain != "" {
+		query += ` AND domain = ?`
+		args = append(args, domain)
+	}
+
+	rows, err := s.query(query+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		logger.Log.Error("Error querying record history ", err.Error())
+		return nil, fmt.Errorf("error querying record history")
+	}
+	defer rows.Close()
+
+	entries := []model.RecordHistory{}
+	for rows.Next() {
+		entry, err := scanRecordHistory(rows)
+		if err != nil {
+			logger.Log.Error("Error scanning record history ", err.Error())
+			return nil, fmt.Errorf("error querying record history")
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// GetRecordHistoryEntry returns one change from the record history.
+func (s *sqlStore) GetRecordHistoryEntry(id int64) (*model.RecordHistory, error) {
+	row := s.queryRow(`SELECT id, uuid, domain, action, old_value, new_value, actor, source_ip, created_at FROM record_history WHERE id = ?`, id)
+
+	entry, err := scanRecordHistory(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying record history entry ", err.Error())
+		return nil, fmt.Errorf("error querying record history entry")
+	}
+
+	return entry, nil
+}
+
+func scanRecordHistory(row interface{ Scan(...interface{}) error }) (*model.RecordHistory, error) {
+	var entry model.RecordHistory
+	var oldJSON, newJSON sql.NullString
+
+	err := row.Scan(&entry.ID, &entry.UUID, &entry.Domain, &entry.Action, &oldJSON, &newJSON, &entry.Actor, &entry.SourceIP, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.OldValue, err = unmarshalRecordValue(oldJSON); err != nil {
+		return nil, err
+	}
+
+	if entry.NewValue, err = unmarshalRecordValue(newJSON); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func recordValue(record *model.Record) *model.RecordValue {
+	if record == nil {
+		return nil
+	}
+
+	value := &model.RecordValue{
+		Domain:     record.Domain,
+		ARecord:    record.ARecord,
+		AAAARecord: record.AAAARecord,
+		Alias:      record.Alias,
+	}
+
+	if record.Views != nil {
+		value.Views = []model.ViewValue{}
+		for _, view := range record.Views {
+			value.Views = append(value.Views, model.ViewValue{
+				View:       view.View,
+				ARecord:    view.ARecord,
+				AAAARecord: view.AAAARecord,
+			})
+		}
+	}
+
+	return value
+}
+
+// sameRecordValue reports whether two values of a record are the same, with
+// their view overrides in the same order.
+func sameRecordValue(a *model.RecordValue, b *model.RecordValue) bool {
+	if a.Domain != b.Domain || a.ARecord != b.ARecord || a.AAAARecord != b.AAAARecord || a.Alias != b.Alias {
+		return false
+	}
+
+	if len(a.Views) != len(b.Views) {
+		return false
+	}
+
+	for i := range a.Views {
+		if a.Views[i] != b.Views[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func marshalRecordValue(value *model.RecordValue) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalRecordValue(s sql.NullString) (*model.RecordValue, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+
+	value := &model.RecordValue{}
+	if err := json.Unmarshal([]byte(s.String), value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations live in migrations/<dialect>/<version>_<name>.sql and are
+// applied in version order, each in its own transaction. Applied migrations
+// must never be edited, changes go into a new one.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s doesn't start with a version number", name)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: strings.TrimSuffix(name, ".sql"), sql: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", migrations[i-1].name, migrations[i].name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// pendingMigrations returns the migrations not applied to the database yet.
+// Without a schema_migrations table, all of them are.
+func (s *sqlStore) pendingMigrations() ([]migration, error) {
+	migrations, err := loadMigrations(s.dialect.name)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+
+	if rows, err := s.query(`SELECT version FROM schema_migrations`); err == nil {
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			if err := rows.Scan(&version); err != nil {
+				return nil, err
+			}
+			applied[version] = true
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// ensureMigrationsTable creates the schema_migrations table, first adopting
+// a database created before migrations were introduced.
+func (s *sqlStore) ensureMigrationsTable() error {
+	var version int
+	err := s.queryRow(`SELECT version FROM schema_migrations LIMIT 1`).Scan(&version)
+	if err == nil || err == sql.ErrNoRows {
+		return nil
+	}
+
+	if s.dialect.adopt != nil {
+		if err := s.dialect.adopt(s); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.db.Exec(s.dialect.migrationsTable); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// migrate applies the pending migrations and returns their names.
+func (s *sqlStore) migrate() ([]string, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	pending, err := s.pendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range pending {
+		applied, err := s.applyMigration(m)
+		if err != nil {
+			return names, fmt.Errorf("migration %s: %w", m.name, err)
+		}
+
+		if applied {
+			names = append(names, m.name)
+		}
+	}
+
+	return names, nil
+}
+
+// applyMigration runs a migration in a transaction, unless another node
+// applied it in the meantime.
+func (s *sqlStore) applyMigration(m migration) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if s.dialect.lock != "" {
+		if _, err := tx.Exec(s.dialect.lock); err != nil {
+			return false, err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.version).Scan(&count); err != nil {
+		return false, err
+	}
+
+	if count > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Migrate brings the schema of the store given by dsn, or the SQLite database
+// at path, up to date. With dryRun the SQL of the pending migrations is
+// written to out instead. It returns the names of the migrations applied, or
+// pending with dryRun.
+func Migrate(dsn string, path string, dryRun bool, out io.Writer) ([]string, error) {
+	store, err := open(dsn, path)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	if !dryRun {
+		return store.migrate()
+	}
+
+	pending, err := store.pendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range pending {
+		fmt.Fprintf(out, "-- %s\n%s\n", m.name, strings.TrimSpace(m.sql))
+		names = append(names, m.name)
+	}
+
+	return names, nil
+}
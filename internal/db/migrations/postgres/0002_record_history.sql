@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS record_history (
+	"id" BIGSERIAL PRIMARY KEY,
+	"uuid" TEXT NOT NULL,
+	"domain" TEXT NOT NULL,
+	"action" TEXT NOT NULL,
+	"old_value" TEXT,
+	"new_value" TEXT,
+	"actor" TEXT NOT NULL,
+	"source_ip" TEXT NOT NULL,
+	"created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_record_history_uuid ON record_history (uuid, id);
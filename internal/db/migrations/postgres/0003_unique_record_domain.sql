@@ -0,0 +1,26 @@
+-- Names claimed by several devices stay with the device that claimed them
+-- first. The records of the others are journaled as deleted and removed.
+INSERT INTO record_history (uuid, domain, action, old_value, new_value, actor, source_ip, created_at)
+SELECT uuid, domain, 'delete',
+	json_build_object('domain', domain, 'ipv4', a_record, 'ipv6', aaaa_record, 'alias', COALESCE(alias, ''))::TEXT,
+	NULL, 'migration', '', CURRENT_TIMESTAMP
+FROM records WHERE EXISTS (
+	SELECT 1 FROM records r WHERE r.domain = records.domain AND (
+		COALESCE(r.created_at, 'epoch') < COALESCE(records.created_at, 'epoch') OR
+		(COALESCE(r.created_at, 'epoch') = COALESCE(records.created_at, 'epoch') AND r.uuid < records.uuid)
+	)
+);
+
+DELETE FROM records WHERE EXISTS (
+	SELECT 1 FROM records r WHERE r.domain = records.domain AND (
+		COALESCE(r.created_at, 'epoch') < COALESCE(records.created_at, 'epoch') OR
+		(COALESCE(r.created_at, 'epoch') = COALESCE(records.created_at, 'epoch') AND r.uuid < records.uuid)
+	)
+);
+
+DELETE FROM delegations WHERE NOT EXISTS (
+	SELECT 1 FROM records r WHERE r.uuid = delegations.uuid AND delegations.domain LIKE '%.' || r.domain
+);
+
+DROP INDEX IF EXISTS idx_records_domain;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_records_domain ON records (domain);
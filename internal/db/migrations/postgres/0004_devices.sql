@@ -0,0 +1,30 @@
+-- Devices are kept apart from the names they hold, so a device can hold
+-- several names. Records are keyed by name instead of by device.
+CREATE TABLE IF NOT EXISTS devices (
+	"uuid" TEXT NOT NULL PRIMARY KEY,
+	"plan" TEXT NOT NULL DEFAULT '',
+	"max_names" INTEGER,
+	"created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO devices (uuid, created_at) SELECT uuid, created_at FROM records;
+
+CREATE TABLE records_by_domain (
+	"domain" TEXT NOT NULL PRIMARY KEY,
+	"uuid" TEXT NOT NULL REFERENCES devices (uuid),
+	"zone" TEXT,
+	"a_record" TEXT,
+	"aaaa_record" TEXT,
+	"alias" TEXT,
+	"created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	"last_update_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO records_by_domain (domain, uuid, zone, a_record, aaaa_record, alias, created_at, last_update_at)
+SELECT domain, uuid, zone, a_record, aaaa_record, alias, created_at, last_update_at FROM records WHERE domain IS NOT NULL;
+
+DROP TABLE records;
+ALTER TABLE records_by_domain RENAME TO records;
+
+CREATE INDEX IF NOT EXISTS idx_records_uuid ON records (uuid);
+CREATE INDEX IF NOT EXISTS idx_records_zone ON records (zone);
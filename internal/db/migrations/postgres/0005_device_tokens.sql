@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS device_tokens (
+	"id" BIGSERIAL PRIMARY KEY,
+	"uuid" TEXT NOT NULL,
+	"prefix" TEXT NOT NULL,
+	"token_hash" TEXT NOT NULL UNIQUE,
+	"scopes" TEXT NOT NULL,
+	"created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	"revoked_at" TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_tokens_uuid ON device_tokens (uuid);
@@ -0,0 +1 @@
+ALTER TABLE devices ADD COLUMN signing_key TEXT;
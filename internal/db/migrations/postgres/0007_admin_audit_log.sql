@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	"id" BIGSERIAL PRIMARY KEY,
+	"actor" TEXT NOT NULL,
+	"method" TEXT NOT NULL,
+	"path" TEXT NOT NULL,
+	"status" INTEGER NOT NULL,
+	"details" TEXT,
+	"source_ip" TEXT NOT NULL DEFAULT '',
+	"created_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
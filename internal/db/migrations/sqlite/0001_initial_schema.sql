@@ -0,0 +1,62 @@
+CREATE TABLE IF NOT EXISTS records (
+	"uuid" TEXT NOT NULL PRIMARY KEY,
+	"domain" TEXT,
+	"zone" TEXT,
+	"a_record" TEXT,
+	"aaaa_record" TEXT,
+	"alias" TEXT,
+	"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP,
+	"last_update_at" DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_records_domain ON records (domain);
+CREATE INDEX IF NOT EXISTS idx_records_zone ON records (zone);
+
+CREATE TABLE IF NOT EXISTS record_views (
+	"domain" TEXT NOT NULL,
+	"view" TEXT NOT NULL,
+	"a_record" TEXT,
+	"aaaa_record" TEXT,
+	PRIMARY KEY (domain, view)
+);
+
+CREATE TABLE IF NOT EXISTS delegations (
+	"domain" TEXT NOT NULL,
+	"nameserver" TEXT NOT NULL,
+	"uuid" TEXT NOT NULL,
+	"a_record" TEXT,
+	"aaaa_record" TEXT,
+	"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (domain, nameserver)
+);
+
+CREATE INDEX IF NOT EXISTS idx_delegations_uuid ON delegations (uuid);
+
+CREATE TABLE IF NOT EXISTS tunnel_relays (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"name" TEXT NOT NULL UNIQUE,
+	"region" TEXT NOT NULL,
+	"a_record" TEXT,
+	"aaaa_record" TEXT,
+	"enabled" BOOLEAN NOT NULL DEFAULT 1,
+	"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP,
+	"last_update_at" DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tunnel_relays_region ON tunnel_relays (region);
+
+CREATE TABLE IF NOT EXISTS tunnel_endpoints (
+	"domain" TEXT NOT NULL PRIMARY KEY,
+	"uuid" TEXT NOT NULL,
+	"relay_id" INTEGER NOT NULL REFERENCES tunnel_relays (id),
+	"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP,
+	"last_update_at" DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tunnel_endpoints_relay ON tunnel_endpoints (relay_id);
+CREATE INDEX IF NOT EXISTS idx_tunnel_endpoints_uuid ON tunnel_endpoints (uuid);
+
+CREATE TABLE IF NOT EXISTS zone_serials (
+	"zone" TEXT NOT NULL PRIMARY KEY,
+	"serial" INTEGER NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS txt_records (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"domain" TEXT NOT NULL,
+	"value" TEXT NOT NULL,
+	"created_at" DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_txt_records_domain ON txt_records (domain);
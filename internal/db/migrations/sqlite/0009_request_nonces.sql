@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS request_nonces (
+	"uuid" TEXT NOT NULL,
+	"nonce" TEXT NOT NULL,
+	"expires_at" INTEGER NOT NULL,
+	PRIMARY KEY (uuid, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_nonces_expires_at ON request_nonces (expires_at);
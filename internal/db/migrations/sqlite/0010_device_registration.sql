@@ -0,0 +1,6 @@
+-- The token issued by registering a device is marked, and the unique index
+-- lets a device hold only one unrevoked registration token, so concurrent
+-- registrations can't both succeed.
+ALTER TABLE device_tokens ADD COLUMN registration INTEGER NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_device_tokens_registration ON device_tokens (uuid) WHERE registration = 1 AND revoked_at IS NULL;
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry is a request to the admin API, kept in the audit log.
+type AuditEntry struct {
+	ID        int64           `db:"id" json:"id"`
+	Actor     string          `db:"actor" json:"actor"`
+	Method    string          `db:"method" json:"method"`
+	Path      string          `db:"path" json:"path"`
+	Status    int             `db:"status" json:"status"`
+	Details   json.RawMessage `db:"details" json:"details,omitempty"`
+	SourceIP  string          `db:"source_ip" json:"source_ip"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
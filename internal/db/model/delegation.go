@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+type Delegation struct {
+	Domain      string             `db:"domain" json:"domain"`
+	UUID        string             `db:"uuid" json:"uuid"`
+	NameServers []DelegationServer `db:"-" json:"nameservers"`
+	CreatedAt   time.Time          `db:"created_at" json:"created_at"`
+}
+
+type DelegationServer struct {
+	Name       string `db:"nameserver" json:"name"`
+	ARecord    string `db:"a_record" json:"ipv4,omitempty"`
+	AAAARecord string `db:"aaaa_record" json:"ipv6,omitempty"`
+}
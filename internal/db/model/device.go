@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+// Device is an identity holding names. MaxNames overrides the name quota of
+// its plan when set.
+type Device struct {
+	UUID      string    `db:"uuid" json:"uuid"`
+	Plan      string    `db:"plan" json:"plan"`
+	MaxNames  *int      `db:"max_names" json:"max_names"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+// Change tells who changed a record, for the record history.
+type Change struct {
+	Actor    string
+	SourceIP string
+	// Action replaces the action derived from the change when set, like
+	// rollback.
+	Action string
+}
+
+// RecordValue is the state of a record at one point in its history. Views
+// is nil for changes journaled before view overrides were.
+type RecordValue struct {
+	Domain     string      `json:"domain"`
+	ARecord    string      `json:"ipv4"`
+	AAAARecord string      `json:"ipv6"`
+	Alias      string      `json:"alias"`
+	Views      []ViewValue `json:"views"`
+}
+
+// ViewValue is the override of a record in one view.
+type ViewValue struct {
+	View       string `json:"view"`
+	ARecord    string `json:"ipv4"`
+	AAAARecord string `json:"ipv6"`
+}
+
+type RecordHistory struct {
+	ID        int64        `db:"id" json:"id"`
+	UUID      string       `db:"uuid" json:"uuid"`
+	Domain    string       `db:"domain" json:"domain"`
+	Action    string       `db:"action" json:"action"`
+	OldValue  *RecordValue `db:"old_value" json:"old_value"`
+	NewValue  *RecordValue `db:"new_value" json:"new_value"`
+	Actor     string       `db:"actor" json:"actor"`
+	SourceIP  string       `db:"source_ip" json:"source_ip"`
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+}
@@ -7,8 +7,30 @@ import (
 type Record struct {
 	UUID         string    `db:"uuid"`
 	Domain       string    `db:"domain"`
+	Zone         string    `db:"zone"`
 	ARecord      string    `db:"a_record"`
 	AAAARecord   string    `db:"aaaa_record"`
+	Alias        string    `db:"alias"`
 	CreatedAt    time.Time `db:"created_at"`
 	LastUpdateAt time.Time `db:"last_update_at"`
+	// Views are the view overrides of the name. Storing a record with nil
+	// views leaves the overrides as they are.
+	Views []RecordView `db:"-" json:",omitempty"`
+}
+
+type RecordView struct {
+	Domain     string `db:"domain"`
+	View       string `db:"view"`
+	ARecord    string `db:"a_record"`
+	AAAARecord string `db:"aaaa_record"`
+}
+
+// RecordFilter selects records across all devices. Query matches part of the
+// name, UUID, addresses or alias; the other fields match exactly when set.
+type RecordFilter struct {
+	Query  string
+	UUID   string
+	Zone   string
+	Offset int
+	Limit  int
 }
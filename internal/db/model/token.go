@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// DeviceToken is a bearer token of a device. Only its hash is stored.
+type DeviceToken struct {
+	ID        int64      `db:"id" json:"id"`
+	UUID      string     `db:"uuid" json:"uuid"`
+	Prefix    string     `db:"prefix" json:"prefix"`
+	Scopes    []string   `db:"scopes" json:"scopes"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
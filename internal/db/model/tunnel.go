@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+type TunnelRelay struct {
+	ID           int64     `db:"id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	Region       string    `db:"region" json:"region"`
+	ARecord      string    `db:"a_record" json:"ipv4"`
+	AAAARecord   string    `db:"aaaa_record" json:"ipv6"`
+	Enabled      bool      `db:"enabled" json:"enabled"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	LastUpdateAt time.Time `db:"last_update_at" json:"last_update_at"`
+}
+
+type TunnelEndpoint struct {
+	Domain       string    `db:"domain" json:"domain"`
+	UUID         string    `db:"uuid" json:"uuid"`
+	RelayID      int64     `db:"relay_id" json:"relay_id"`
+	RelayName    string    `db:"-" json:"relay"`
+	Region       string    `db:"-" json:"region"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	LastUpdateAt time.Time `db:"last_update_at" json:"last_update_at"`
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"time"
+)
+
+// UseNonce records the nonce of a signed request of a device until expires,
+// reporting false when it was used before and hasn't expired yet. Nonces are
+// kept in the store, so a request can't be replayed on another node sharing
+// it either.
+func (s *sqlStore) UseNonce(uuid string, nonce string, expires time.Time) (bool, error) {
+	upsertSQL := `
+	INSERT INTO request_nonces (uuid, nonce, expires_at) VALUES (?, ?, ?)
+	ON CONFLICT(uuid, nonce) DO UPDATE SET expires_at = excluded.expires_at
+	WHERE request_nonces.expires_at < ?
+	`
+
+	result, err := s.exec(upsertSQL, uuid, nonce, expires.Unix(), time.Now().Unix())
+	if err != nil {
+		logger.Log.Error("Error inserting request nonce ", err.Error())
+		return false, fmt.Errorf("error checking request nonce")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// DeleteExpiredNonces drops the nonces whose requests are too old to be
+// accepted anyway, run periodically in the background.
+func (s *sqlStore) DeleteExpiredNonces() {
+	result, err := s.exec(`DELETE FROM request_nonces WHERE expires_at < ?`, time.Now().Unix())
+	if err != nil {
+		logger.Log.Warn("Error deleting expired request nonces ", err.Error())
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		logger.Log.Debug("Deleted ", affected, " expired request nonces")
+	}
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+)
+
+func openPostgres(dsn string) (*sqlStore, error) {
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.Ping(); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return &sqlStore{
+		db: database,
+		dialect: dialect{
+			name:     "postgres",
+			numbered: true,
+			migrationsTable: `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				"version" INTEGER NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL,
+				"applied_at" TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			// Serializes nodes migrating a shared database at the same time
+			lock:            `SELECT pg_advisory_xact_lock(4463646)`,
+			uniqueViolation: postgresUniqueViolation,
+		},
+	}, nil
+}
+
+func postgresUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect holds what sets the SQL databases apart.
+type dialect struct {
+	name string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered        bool
+	migrationsTable string
+	// lock runs first in every migration transaction, if set
+	lock string
+	// adopt prepares databases created before migrations were introduced
+	adopt func(s *sqlStore) error
+	// close runs before the connection is closed
+	close func(s *sqlStore) error
+	// uniqueViolation reports whether err is a violated unique constraint
+	uniqueViolation func(err error) bool
+}
+
+// sqlStore is the Store on an SQL database. Queries are written with ?
+// placeholders and rebound for the dialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+	serials serialCache
+}
+
+// sqlTx is a transaction rebinding its queries like the store does.
+type sqlTx struct {
+	*sql.Tx
+	store *sqlStore
+}
+
+// open connects to the PostgreSQL database of a postgres:// DSN, or opens the
+// SQLite database at path when dsn is empty.
+func open(dsn string, path string) (*sqlStore, error) {
+	switch {
+	case dsn == "":
+		return openSQLite(path)
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return openPostgres(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database DSN, expected postgres://")
+	}
+}
+
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
+
+func (s *sqlStore) begin() (*sqlTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlTx{Tx: tx, store: s}, nil
+}
+
+func (t *sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.store.rebind(query), args...)
+}
+
+func (t *sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.store.rebind(query), args...)
+}
+
+func (t *sqlTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.store.rebind(query), args...)
+}
+
+// Ping reports whether the database can be reached, for health checks.
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	if s.dialect.close != nil {
+		if err := s.dialect.close(s); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Close()
+}
+
+// baseSerial is the first SOA serial of the day, in the YYYYMMDDnn format.
+func baseSerial() uint32 {
+	now := time.Now()
+	return uint32(now.Year()*1000000+int(now.Month())*10000+now.Day()*100) + 1
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// sqliteOptions make transactions take the write lock when they begin, so
+// concurrent writers queue up behind each other instead of failing with
+// SQLITE_BUSY when they upgrade a read, and wait for the lock instead of
+// failing right away.
+const sqliteOptions = "_busy_timeout=10000&_txlock=immediate"
+
+func openSQLite(path string) (*sqlStore, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	database, err := sql.Open("sqlite3", path+separator+sqliteOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := database.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return &sqlStore{
+		db: database,
+		dialect: dialect{
+			name: "sqlite",
+			migrationsTable: `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				"version" INTEGER NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL,
+				"applied_at" DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			adopt:           adoptSQLite,
+			close:           checkpointSQLite,
+			uniqueViolation: sqliteUniqueViolation,
+		},
+	}, nil
+}
+
+// adoptSQLite adds the columns that databases created before migrations were
+// introduced may lack, so the initial migration can create its indexes.
+func adoptSQLite(s *sqlStore) error {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'records'`).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	if err := ensureColumn(s.db, "records", "zone", "TEXT"); err != nil {
+		return fmt.Errorf("adding zone column to records table: %w", err)
+	}
+
+	if err := ensureColumn(s.db, "records", "alias", "TEXT"); err != nil {
+		return fmt.Errorf("adding alias column to records table: %w", err)
+	}
+
+	return nil
+}
+
+// checkpointSQLite moves the write-ahead log into the database file.
+func checkpointSQLite(s *sqlStore) error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		logger.Log.Warn("Error checkpointing database ", err.Error())
+	}
+
+	return nil
+}
+
+// ensureColumn adds a column to an existing table unless it is already there.
+func ensureColumn(database *sql.DB, table string, column string, definition string) error {
+	rows, err := database.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = database.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "%s" %s`, table, column, definition))
+	return err
+}
+
+func sqliteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
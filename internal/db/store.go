@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"time"
+)
+
+// Store is the storage of devices, their tokens and records and the history of
+// these, TXT records, view overrides, delegations, tunnels and the admin
+// audit log. Several dddns nodes can share a store when it is backed by a
+// database server.
+type Store interface {
+	InsertOrUpdateRecord(record *model.Record, zone string, quota int, change model.Change) (bool, error)
+	GetRecords(uuid string) ([]model.Record, error)
+	GetRecordByDomain(domain string) (*model.Record, error)
+	GetRecordHolders(domains []string) (map[string]string, error)
+	IsDomainTaken(domain string, uuid string) (bool, error)
+	DeleteRecord(uuid string, domain string, change model.Change) (bool, error)
+	SearchRecords(filter model.RecordFilter) ([]model.Record, int, error)
+	ReassignRecord(domain string, uuid string, change model.Change) (*model.Record, error)
+	ZoneSerial(zone string) (uint32, error)
+
+	GetTXTRecords(domain string) ([]string, error)
+	SetTXTRecords(uuid string, domain string, values []string) (bool, error)
+
+	GetRecordHistory(uuid string, domain string, limit int) ([]model.RecordHistory, error)
+	GetRecordHistoryEntry(id int64) (*model.RecordHistory, error)
+
+	GetDevice(uuid string) (*model.Device, error)
+	SetDevice(device *model.Device) (bool, error)
+	GetSigningKey(uuid string) (string, error)
+	SetSigningKey(uuid string, key string) (bool, error)
+	UseNonce(uuid string, nonce string, expires time.Time) (bool, error)
+	DeleteExpiredNonces()
+
+	CreateDeviceToken(token *model.DeviceToken, hash string) (*model.DeviceToken, error)
+	RegisterDevice(token *model.DeviceToken, hash string) (*model.DeviceToken, error)
+	HasDeviceTokens(uuid string) (bool, error)
+	GetDeviceTokenByHash(hash string) (*model.DeviceToken, error)
+	GetDeviceToken(uuid string, id int64) (*model.DeviceToken, error)
+	GetDeviceTokens(uuid string) ([]model.DeviceToken, error)
+	RevokeDeviceToken(uuid string, id int64) (bool, error)
+	RotateDeviceToken(uuid string, id int64, prefix string, hash string) (*model.DeviceToken, error)
+
+	InsertAuditEntry(entry *model.AuditEntry) error
+	GetAuditLog(offset int, limit int) ([]model.AuditEntry, int, error)
+
+	GetRecordView(domain string, view string) (*model.RecordView, error)
+	GetRecordViews(domain string) ([]model.RecordView, error)
+
+	SetDelegation(delegation *model.Delegation) (bool, error)
+	GetDelegations(uuid string) ([]model.Delegation, error)
+	FindDelegation(name string) (*model.Delegation, error)
+	DeleteDelegation(domain string, uuid string) (bool, error)
+
+	UpsertTunnelRelay(relay *model.TunnelRelay) (*model.TunnelRelay, error)
+	GetTunnelRelay(name string) (*model.TunnelRelay, error)
+	GetTunnelRelays(region string) ([]model.TunnelRelay, error)
+	DeleteTunnelRelay(name string) (bool, error)
+	PickTunnelRelay(region string, exclude int64) (*model.TunnelRelay, error)
+	AssignTunnelEndpoint(endpoint *model.TunnelEndpoint, zone string) (bool, error)
+	GetTunnelEndpoint(domain string) (*model.TunnelEndpoint, error)
+	GetTunnelEndpoints(relay string, uuid string) ([]model.TunnelEndpoint, error)
+	DeleteTunnelEndpoint(domain string) (bool, error)
+	GetTunnelTarget(domain string) (*model.TunnelRelay, error)
+	DrainTunnelRelay(relay *model.TunnelRelay, region string) (int, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+var _ Store = (*sqlStore)(nil)
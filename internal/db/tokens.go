@@ -0,0 +1,224 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"strings"
+)
+
+const deviceTokenColumns = `id, uuid, prefix, scopes, created_at, revoked_at`
+
+// ErrDeviceRegistered is returned when registering a device that already
+// holds a token.
+var ErrDeviceRegistered = errors.New("device is already registered")
+
+// CreateDeviceToken stores a token of a device by its hash, registering the
+// device when it is new.
+func (s *sqlStore) CreateDeviceToken(token *model.DeviceToken, hash string) (*model.DeviceToken, error) {
+	return s.createDeviceToken(token, hash, false)
+}
+
+// RegisterDevice stores the first token of a device by its hash, returning
+// ErrDeviceRegistered when the device already holds one. The check and the
+// insert share a transaction, and a unique index keeps concurrent
+// registrations from both succeeding.
+func (s *sqlStore) RegisterDevice(token *model.DeviceToken, hash string) (*model.DeviceToken, error) {
+	return s.createDeviceToken(token, hash, true)
+}
+
+func (s *sqlStore) createDeviceToken(token *model.DeviceToken, hash string, registration bool) (*model.DeviceToken, error) {
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return nil, fmt.Errorf("error creating device token")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO devices (uuid) VALUES (?) ON CONFLICT(uuid) DO NOTHING`, token.UUID); err != nil {
+		logger.Log.Error("Error inserting device ", err.Error())
+		return nil, fmt.Errorf("error creating device token")
+	}
+
+	flag := 0
+	if registration {
+		held, err := hasDeviceTokens(tx.QueryRow, token.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("error creating device token")
+		}
+
+		if held {
+			return nil, ErrDeviceRegistered
+		}
+
+		flag = 1
+	}
+
+	_, err = tx.Exec(`INSERT INTO device_tokens (uuid, prefix, token_hash, scopes, registration) VALUES (?, ?, ?, ?, ?)`,
+		token.UUID, token.Prefix, hash, strings.Join(token.Scopes, ","), flag)
+	if err != nil {
+		if registration && s.dialect.uniqueViolation(err) {
+			return nil, ErrDeviceRegistered
+		}
+
+		logger.Log.Error("Error inserting device token ", err.Error())
+		return nil, fmt.Errorf("error creating device token")
+	}
+
+	if err := tx.Commit(); err != nil {
+		if registration && s.dialect.uniqueViolation(err) {
+			return nil, ErrDeviceRegistered
+		}
+
+		logger.Log.Error("Error committing device token ", err.Error())
+		return nil, fmt.Errorf("error creating device token")
+	}
+
+	logger.Log.Debug("Device token created for ", token.UUID)
+
+	return s.GetDeviceTokenByHash(hash)
+}
+
+// HasDeviceTokens reports whether a device holds a token that wasn't revoked.
+func (s *sqlStore) HasDeviceTokens(uuid string) (bool, error) {
+	return hasDeviceTokens(s.queryRow, uuid)
+}
+
+func hasDeviceTokens(queryRow func(string, ...interface{}) *sql.Row, uuid string) (bool, error) {
+	var held int
+
+	err := queryRow(`SELECT COUNT(*) FROM device_tokens WHERE uuid = ? AND revoked_at IS NULL`, uuid).Scan(&held)
+	if err != nil {
+		logger.Log.Error("Error querying device tokens ", err.Error())
+		return false, fmt.Errorf("error querying device tokens")
+	}
+
+	return held > 0, nil
+}
+
+// GetDeviceTokenByHash returns the token with a hash unless it was revoked.
+func (s *sqlStore) GetDeviceTokenByHash(hash string) (*model.DeviceToken, error) {
+	return getDeviceToken(s.queryRow, `token_hash = ? AND revoked_at IS NULL`, hash)
+}
+
+// GetDeviceToken returns a token of a device unless it was revoked.
+func (s *sqlStore) GetDeviceToken(uuid string, id int64) (*model.DeviceToken, error) {
+	return getDeviceToken(s.queryRow, `id = ? AND uuid = ? AND revoked_at IS NULL`, id, uuid)
+}
+
+// GetDeviceTokens returns the tokens of a device that weren't revoked.
+func (s *sqlStore) GetDeviceTokens(uuid string) ([]model.DeviceToken, error) {
+	rows, err := s.query(`SELECT `+deviceTokenColumns+` FROM device_tokens WHERE uuid = ? AND revoked_at IS NULL ORDER BY id`, uuid)
+	if err != nil {
+		logger.Log.Error("Error querying device tokens ", err.Error())
+		return nil, fmt.Errorf("error querying device tokens")
+	}
+	defer rows.Close()
+
+	tokens := []model.DeviceToken{}
+	for rows.Next() {
+		token, err := scanDeviceToken(rows)
+		if err != nil {
+			logger.Log.Error("Error scanning device token ", err.Error())
+			return nil, fmt.Errorf("error querying device tokens")
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeDeviceToken revokes a token of a device, reporting whether it had
+// such a token.
+func (s *sqlStore) RevokeDeviceToken(uuid string, id int64) (bool, error) {
+	result, err := s.exec(`UPDATE device_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND uuid = ? AND revoked_at IS NULL`, id, uuid)
+	if err != nil {
+		logger.Log.Error("Error revoking device token ", err.Error())
+		return false, fmt.Errorf("error revoking device token")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	logger.Log.Debug("Device token ", id, " revoked for ", uuid)
+
+	return affected > 0, nil
+}
+
+// RotateDeviceToken revokes a token of a device and stores its replacement,
+// with the same scopes, by hash at once. It returns nil when the device had no
+// such token.
+func (s *sqlStore) RotateDeviceToken(uuid string, id int64, prefix string, hash string) (*model.DeviceToken, error) {
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return nil, fmt.Errorf("error rotating device token")
+	}
+	defer tx.Rollback()
+
+	old, err := getDeviceToken(tx.QueryRow, `id = ? AND uuid = ? AND revoked_at IS NULL`, id, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("error rotating device token")
+	}
+
+	if old == nil {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(`UPDATE device_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+		logger.Log.Error("Error revoking device token ", err.Error())
+		return nil, fmt.Errorf("error rotating device token")
+	}
+
+	_, err = tx.Exec(`INSERT INTO device_tokens (uuid, prefix, token_hash, scopes) VALUES (?, ?, ?, ?)`,
+		old.UUID, prefix, hash, strings.Join(old.Scopes, ","))
+	if err != nil {
+		logger.Log.Error("Error inserting device token ", err.Error())
+		return nil, fmt.Errorf("error rotating device token")
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing device token rotation ", err.Error())
+		return nil, fmt.Errorf("error rotating device token")
+	}
+
+	logger.Log.Debug("Device token ", id, " rotated for ", old.UUID)
+
+	return s.GetDeviceTokenByHash(hash)
+}
+
+func getDeviceToken(queryRow func(string, ...interface{}) *sql.Row, where string, args ...interface{}) (*model.DeviceToken, error) {
+	token, err := scanDeviceToken(queryRow(`SELECT `+deviceTokenColumns+` FROM device_tokens WHERE `+where, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying device token ", err.Error())
+		return nil, fmt.Errorf("error querying device token")
+	}
+
+	return token, nil
+}
+
+func scanDeviceToken(row interface{ Scan(...interface{}) error }) (*model.DeviceToken, error) {
+	var token model.DeviceToken
+	var scopes string
+	var revokedAt sql.NullTime
+
+	if err := row.Scan(&token.ID, &token.UUID, &token.Prefix, &scopes, &token.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Split(scopes, ",")
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
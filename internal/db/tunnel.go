@@ -0,0 +1,332 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"strings"
+)
+
+const tunnelEndpointColumns = `e.domain, e.uuid, e.relay_id, r.name, r.region, e.created_at, e.last_update_at`
+
+func (s *sqlStore) UpsertTunnelRelay(relay *model.TunnelRelay) (*model.TunnelRelay, error) {
+	upsertSQL := `
+	INSERT INTO tunnel_relays (name, region, a_record, aaaa_record, enabled, last_update_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(name) DO UPDATE SET
+		region = excluded.region,
+		a_record = excluded.a_record,
+		aaaa_record = excluded.aaaa_record,
+		enabled = excluded.enabled,
+		last_update_at = CURRENT_TIMESTAMP;
+	`
+
+	_, err := s.exec(upsertSQL, relay.Name, relay.Region, relay.ARecord, relay.AAAARecord, relay.Enabled)
+	if err != nil {
+		logger.Log.Error("Error inserting or updating tunnel relay ", err.Error())
+		return nil, fmt.Errorf("error inserting or updating tunnel relay")
+	}
+
+	logger.Log.Debug("Tunnel relay inserted or updated ", relay.Name)
+
+	return s.GetTunnelRelay(relay.Name)
+}
+
+func (s *sqlStore) GetTunnelRelay(name string) (*model.TunnelRelay, error) {
+	query := `SELECT id, name, region, a_record, aaaa_record, enabled, created_at, last_update_at FROM tunnel_relays WHERE name = ?`
+
+	relay := &model.TunnelRelay{}
+	err := s.queryRow(query, name).Scan(&relay.ID, &relay.Name, &relay.Region, &relay.ARecord, &relay.AAAARecord, &relay.Enabled, &relay.CreatedAt, &relay.LastUpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying tunnel relay ", err.Error())
+		return nil, fmt.Errorf("error querying tunnel relay")
+	}
+
+	return relay, nil
+}
+
+// GetTunnelRelays returns every relay, or only the relays of the given region
+// when region is not empty.
+func (s *sqlStore) GetTunnelRelays(region string) ([]model.TunnelRelay, error) {
+	query := `SELECT id, name, region, a_record, aaaa_record, enabled, created_at, last_update_at FROM tunnel_relays`
+	var args []interface{}
+
+	if region != "" {
+		query += ` WHERE region = ?`
+		args = append(args, region)
+	}
+
+	query += ` ORDER BY region, name`
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		logger.Log.Error("Error querying tunnel relays ", err.Error())
+		return nil, fmt.Errorf("error querying tunnel relays")
+	}
+	defer rows.Close()
+
+	relays := []model.TunnelRelay{}
+	for rows.Next() {
+		var relay model.TunnelRelay
+		if err := rows.Scan(&relay.ID, &relay.Name, &relay.Region, &relay.ARecord, &relay.AAAARecord, &relay.Enabled, &relay.CreatedAt, &relay.LastUpdateAt); err != nil {
+			logger.Log.Error("Error scanning tunnel relay ", err.Error())
+			return nil, fmt.Errorf("error querying tunnel relays")
+		}
+		relays = append(relays, relay)
+	}
+
+	return relays, rows.Err()
+}
+
+func (s *sqlStore) DeleteTunnelRelay(name string) (bool, error) {
+	relay, err := s.GetTunnelRelay(name)
+	if err != nil {
+		return false, err
+	}
+
+	if relay == nil {
+		return false, nil
+	}
+
+	var count int
+	err = s.queryRow(`SELECT COUNT(*) FROM tunnel_endpoints WHERE relay_id = ?`, relay.ID).Scan(&count)
+	if err != nil {
+		logger.Log.Error("Error counting tunnel endpoints ", err.Error())
+		return false, fmt.Errorf("error deleting tunnel relay")
+	}
+
+	if count > 0 {
+		return false, fmt.Errorf("relay %s still has %d tunnel endpoints assigned", name, count)
+	}
+
+	_, err = s.exec(`DELETE FROM tunnel_relays WHERE id = ?`, relay.ID)
+	if err != nil {
+		logger.Log.Error("Error deleting tunnel relay ", err.Error())
+		return false, fmt.Errorf("error deleting tunnel relay")
+	}
+
+	logger.Log.Debug("Tunnel relay deleted ", name)
+
+	return true, nil
+}
+
+// PickTunnelRelay returns the enabled relay of a region with the fewest
+// endpoints assigned to it, skipping the relay with id exclude.
+func (s *sqlStore) PickTunnelRelay(region string, exclude int64) (*model.TunnelRelay, error) {
+	_, name, err := pickTunnelRelay(s.queryRow, region, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetTunnelRelay(name)
+}
+
+// pickTunnelRelay returns the ID and name of the relay PickTunnelRelay picks,
+// read with queryRow of either the store or a transaction.
+func pickTunnelRelay(queryRow func(string, ...interface{}) *sql.Row, region string, exclude int64) (int64, string, error) {
+	query := `
+	SELECT r.id, r.name FROM tunnel_relays r
+	LEFT JOIN tunnel_endpoints e ON e.relay_id = r.id
+	WHERE r.region = ? AND r.enabled AND r.id != ?
+	GROUP BY r.id, r.name
+	ORDER BY COUNT(e.domain), r.name
+	LIMIT 1
+	`
+
+	var id int64
+	var name string
+	err := queryRow(query, region, exclude).Scan(&id, &name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", fmt.Errorf("no enabled tunnel relay available in region %s", region)
+		}
+
+		logger.Log.Error("Error picking tunnel relay ", err.Error())
+		return 0, "", fmt.Errorf("error picking tunnel relay")
+	}
+
+	return id, name, nil
+}
+
+func (s *sqlStore) AssignTunnelEndpoint(endpoint *model.TunnelEndpoint, domain string) (bool, error) {
+	if !strings.HasSuffix(endpoint.Domain, ".tunnel."+domain) {
+		return false, fmt.Errorf("tunnel domain requested %s isn't under tunnel.%s", endpoint.Domain, domain)
+	}
+
+	upsertSQL := `
+	INSERT INTO tunnel_endpoints (domain, uuid, relay_id, last_update_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(domain) DO UPDATE SET
+		uuid = excluded.uuid,
+		relay_id = excluded.relay_id,
+		last_update_at = CURRENT_TIMESTAMP;
+	`
+
+	_, err := s.exec(upsertSQL, endpoint.Domain, endpoint.UUID, endpoint.RelayID)
+	if err != nil {
+		logger.Log.Error("Error assigning tunnel endpoint ", err.Error())
+		return false, fmt.Errorf("error assigning tunnel endpoint")
+	}
+
+	logger.Log.Debug("Tunnel endpoint ", endpoint.Domain, " assigned to relay ", endpoint.RelayID)
+
+	return true, nil
+}
+
+func (s *sqlStore) GetTunnelEndpoint(domain string) (*model.TunnelEndpoint, error) {
+	query := `SELECT ` + tunnelEndpointColumns + ` FROM tunnel_endpoints e JOIN tunnel_relays r ON r.id = e.relay_id WHERE e.domain = ?`
+
+	endpoint := &model.TunnelEndpoint{}
+	err := s.queryRow(query, domain).Scan(&endpoint.Domain, &endpoint.UUID, &endpoint.RelayID, &endpoint.RelayName, &endpoint.Region, &endpoint.CreatedAt, &endpoint.LastUpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying tunnel endpoint ", err.Error())
+		return nil, fmt.Errorf("error querying tunnel endpoint")
+	}
+
+	return endpoint, nil
+}
+
+// GetTunnelEndpoints lists endpoints, optionally filtered by relay name and/or
+// device UUID.
+func (s *sqlStore) GetTunnelEndpoints(relay string, uuid string) ([]model.TunnelEndpoint, error) {
+	query := `SELECT ` + tunnelEndpointColumns + ` FROM tunnel_endpoints e JOIN tunnel_relays r ON r.id = e.relay_id WHERE 1 = 1`
+	var args []interface{}
+
+	if relay != "" {
+		query += ` AND r.name = ?`
+		args = append(args, relay)
+	}
+
+	if uuid != "" {
+		query += ` AND e.uuid = ?`
+		args = append(args, uuid)
+	}
+
+	query += ` ORDER BY e.domain`
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		logger.Log.Error("Error querying tunnel endpoints ", err.Error())
+		return nil, fmt.Errorf("error querying tunnel endpoints")
+	}
+	defer rows.Close()
+
+	endpoints := []model.TunnelEndpoint{}
+	for rows.Next() {
+		var endpoint model.TunnelEndpoint
+		if err := rows.Scan(&endpoint.Domain, &endpoint.UUID, &endpoint.RelayID, &endpoint.RelayName, &endpoint.Region, &endpoint.CreatedAt, &endpoint.LastUpdateAt); err != nil {
+			logger.Log.Error("Error scanning tunnel endpoint ", err.Error())
+			return nil, fmt.Errorf("error querying tunnel endpoints")
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+func (s *sqlStore) DeleteTunnelEndpoint(domain string) (bool, error) {
+	result, err := s.exec(`DELETE FROM tunnel_endpoints WHERE domain = ?`, domain)
+	if err != nil {
+		logger.Log.Error("Error deleting tunnel endpoint ", err.Error())
+		return false, fmt.Errorf("error deleting tunnel endpoint")
+	}
+
+	affected, _ := result.RowsAffected()
+
+	logger.Log.Debug("Tunnel endpoint deleted ", domain)
+
+	return affected > 0, nil
+}
+
+// GetTunnelTarget returns the relay serving a tunnel name, or nil if the name
+// isn't assigned or its relay is disabled.
+func (s *sqlStore) GetTunnelTarget(domain string) (*model.TunnelRelay, error) {
+	query := `
+	SELECT r.id, r.name, r.region, r.a_record, r.aaaa_record, r.enabled, r.created_at, r.last_update_at
+	FROM tunnel_endpoints e JOIN tunnel_relays r ON r.id = e.relay_id
+	WHERE e.domain = ? AND r.enabled
+	`
+
+	relay := &model.TunnelRelay{}
+	err := s.queryRow(query, domain).Scan(&relay.ID, &relay.Name, &relay.Region, &relay.ARecord, &relay.AAAARecord, &relay.Enabled, &relay.CreatedAt, &relay.LastUpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying tunnel target ", err.Error())
+		return nil, fmt.Errorf("error querying tunnel target")
+	}
+
+	return relay, nil
+}
+
+// DrainTunnelRelay moves every endpoint of a relay onto the least loaded
+// enabled relays of the target region and returns how many were moved. The
+// endpoints move in one transaction, so either all of them do or none.
+func (s *sqlStore) DrainTunnelRelay(relay *model.TunnelRelay, region string) (int, error) {
+	if region == "" {
+		region = relay.Region
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return 0, fmt.Errorf("error draining tunnel relay")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT domain FROM tunnel_endpoints WHERE relay_id = ? ORDER BY domain`, relay.ID)
+	if err != nil {
+		logger.Log.Error("Error querying tunnel endpoints ", err.Error())
+		return 0, fmt.Errorf("error draining tunnel relay")
+	}
+
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			rows.Close()
+			logger.Log.Error("Error scanning tunnel endpoint ", err.Error())
+			return 0, fmt.Errorf("error draining tunnel relay")
+		}
+		domains = append(domains, domain)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		logger.Log.Error("Error querying tunnel endpoints ", err.Error())
+		return 0, fmt.Errorf("error draining tunnel relay")
+	}
+
+	for _, domain := range domains {
+		target, _, err := pickTunnelRelay(tx.QueryRow, region, relay.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(`UPDATE tunnel_endpoints SET relay_id = ?, last_update_at = CURRENT_TIMESTAMP WHERE domain = ?`, target, domain)
+		if err != nil {
+			logger.Log.Error("Error reassigning tunnel endpoint ", err.Error())
+			return 0, fmt.Errorf("error reassigning tunnel endpoint %s", domain)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing tunnel relay drain ", err.Error())
+		return 0, fmt.Errorf("error draining tunnel relay")
+	}
+
+	logger.Log.Debug("Drained ", len(domains), " tunnel endpoints from relay ", relay.Name)
+
+	return len(domains), nil
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+)
+
+// GetTXTRecords returns the TXT values of a name, in the order they were set.
+func (s *sqlStore) GetTXTRecords(domain string) ([]string, error) {
+	rows, err := s.query(`SELECT value FROM txt_records WHERE domain = ? ORDER BY id`, domain)
+	if err != nil {
+		logger.Log.Error("Error querying TXT records ", err.Error())
+		return nil, fmt.Errorf("error querying TXT records")
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			logger.Log.Error("Error scanning TXT record ", err.Error())
+			return nil, fmt.Errorf("error querying TXT records")
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// SetTXTRecords replaces the TXT values of a name held by a device, an empty
+// list removing them all. It reports false when the device doesn't hold the
+// name.
+func (s *sqlStore) SetTXTRecords(uuid string, domain string, values []string) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		logger.Log.Error("Error starting transaction ", err.Error())
+		return false, fmt.Errorf("error setting TXT records")
+	}
+	defer tx.Rollback()
+
+	record, err := getRecord(tx.QueryRow, `uuid = ? AND domain = ?`, uuid, domain)
+	if err != nil {
+		return false, fmt.Errorf("error setting TXT records")
+	}
+
+	if record == nil {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM txt_records WHERE domain = ?`, domain); err != nil {
+		logger.Log.Error("Error deleting TXT records ", err.Error())
+		return false, fmt.Errorf("error setting TXT records")
+	}
+
+	for _, value := range values {
+		if _, err := tx.Exec(`INSERT INTO txt_records (domain, value) VALUES (?, ?)`, domain, value); err != nil {
+			logger.Log.Error("Error inserting TXT record ", err.Error())
+			return false, fmt.Errorf("error setting TXT records")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Log.Error("Error committing TXT records ", err.Error())
+		return false, fmt.Errorf("error setting TXT records")
+	}
+
+	s.bumpSerial(record.Zone)
+
+	logger.Log.Debug("TXT records set for ", domain, ": ", len(values))
+
+	return true, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+)
+
+func (s *sqlStore) GetRecordView(domain string, view string) (*model.RecordView, error) {
+	query := `SELECT domain, view, a_record, aaaa_record FROM record_views WHERE domain = ? AND view = ?`
+
+	override := &model.RecordView{}
+	err := s.queryRow(query, domain, view).Scan(&override.Domain, &override.View, &override.ARecord, &override.AAAARecord)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		logger.Log.Error("Error querying record view ", err.Error())
+		return nil, fmt.Errorf("error querying record view")
+	}
+
+	return override, nil
+}
+
+func (s *sqlStore) GetRecordViews(domain string) ([]model.RecordView, error) {
+	return getRecordViews(s.query, domain)
+}
+
+// getRecordViews returns the view overrides of a domain sorted by view, read
+// with query of either the store or a transaction.
+func getRecordViews(query func(string, ...interface{}) (*sql.Rows, error), domain string) ([]model.RecordView, error) {
+	rows, err := query(`SELECT domain, view, a_record, aaaa_record FROM record_views WHERE domain = ? ORDER BY view`, domain)
+	if err != nil {
+		logger.Log.Error("Error querying record views ", err.Error())
+		return nil, fmt.Errorf("error querying record views")
+	}
+	defer rows.Close()
+
+	overrides := []model.RecordView{}
+	for rows.Next() {
+		var override model.RecordView
+		if err := rows.Scan(&override.Domain, &override.View, &override.ARecord, &override.AAAARecord); err != nil {
+			logger.Log.Error("Error scanning record view ", err.Error())
+			return nil, fmt.Errorf("error querying record views")
+		}
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+// setRecordViews replaces all view overrides of a domain within tx.
+func setRecordViews(tx *sqlTx, domain string, overrides []model.RecordView) error {
+	if _, err := tx.Exec(`DELETE FROM record_views WHERE domain = ?`, domain); err != nil {
+		logger.Log.Error("Error deleting record views ", err.Error())
+		return err
+	}
+
+	for _, override := range overrides {
+		_, err := tx.Exec(`INSERT INTO record_views (domain, view, a_record, aaaa_record) VALUES (?, ?, ?, ?)`, domain, override.View, override.ARecord, override.AAAARecord)
+		if err != nil {
+			logger.Log.Error("Error inserting record view ", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
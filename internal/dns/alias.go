@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	aliasMinTTL      = 10
+	aliasMaxTTL      = 3600
+	aliasNegativeTTL = 60
+	aliasCacheSize   = 4096
+)
+
+// Resolver looks up the addresses of a name for ALIAS records, returning them
+// along with the TTL they may be cached for.
+type Resolver interface {
+	Resolve(name string, qtype uint16) ([]net.IP, uint32, error)
+}
+
+// UpstreamResolver resolves names through a recursive resolver.
+type UpstreamResolver struct {
+	Addr   string
+	Client *dns.Client
+}
+
+func NewUpstreamResolver(addr string, timeout time.Duration) *UpstreamResolver {
+	return &UpstreamResolver{
+		Addr:   addr,
+		Client: &dns.Client{Net: "udp", Timeout: timeout},
+	}
+}
+
+func (u *UpstreamResolver) Resolve(name string, qtype uint16) ([]net.IP, uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	r, _, err := u.Client.Exchange(m, u.Addr)
+	if err == nil && r.Truncated {
+		tcp := *u.Client
+		tcp.Net = "tcp"
+		r, _, err = tcp.Exchange(m, u.Addr)
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("upstream answered %s for %s", dns.RcodeToString[r.Rcode], name)
+	}
+
+	var addresses []net.IP
+	var ttl uint32
+
+	for i, rr := range r.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			if qtype == dns.TypeA {
+				addresses = append(addresses, record.A)
+			}
+		case *dns.AAAA:
+			if qtype == dns.TypeAAAA {
+				addresses = append(addresses, record.AAAA)
+			}
+		}
+
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	if len(addresses) == 0 {
+		ttl = aliasNegativeTTL
+	}
+
+	return addresses, ttl, nil
+}
+
+type aliasKey struct {
+	target string
+	qtype  uint16
+}
+
+type aliasEntry struct {
+	addresses []net.IP
+	expires   time.Time
+}
+
+// aliasCache keeps resolved ALIAS targets for their TTL.
+type aliasCache struct {
+	mu      sync.Mutex
+	entries map[aliasKey]aliasEntry
+}
+
+func (c *aliasCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// clear drops every entry, used when the resolver changes.
+func (c *aliasCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[aliasKey]aliasEntry)
+}
+
+// resolveAlias returns the addresses of an ALIAS target and how many seconds
+// they are still valid for.
+func (s *DNSServer) resolveAlias(resolver Resolver, target string, qtype uint16) ([]net.IP, uint32, error) {
+	if resolver == nil {
+		return nil, 0, fmt.Errorf("no resolver configured for ALIAS records")
+	}
+
+	key := aliasKey{target: dns.Fqdn(target), qtype: qtype}
+	now := time.Now()
+
+	s.aliasCache.mu.Lock()
+	entry, ok := s.aliasCache.entries[key]
+	s.aliasCache.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.addresses, uint32(entry.expires.Sub(now).Seconds()) + 1, nil
+	}
+
+	addresses, ttl, err := resolver.Resolve(key.target, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if ttl < aliasMinTTL {
+		ttl = aliasMinTTL
+	} else if ttl > aliasMaxTTL {
+		ttl = aliasMaxTTL
+	}
+
+	if s.aliasCache.size() >= aliasCacheSize {
+		s.pruneAliasCache()
+	}
+
+	s.aliasCache.mu.Lock()
+	s.aliasCache.entries[key] = aliasEntry{addresses: addresses, expires: now.Add(time.Duration(ttl) * time.Second)}
+	s.aliasCache.mu.Unlock()
+
+	return addresses, ttl, nil
+}
+
+// pruneAliasCache drops expired entries so targets no longer used don't
+// pile up.
+func (s *DNSServer) pruneAliasCache() {
+	if s.aliasCache == nil {
+		return
+	}
+
+	now := time.Now()
+
+	s.aliasCache.mu.Lock()
+	defer s.aliasCache.mu.Unlock()
+
+	for key, entry := range s.aliasCache.entries {
+		if now.After(entry.expires) {
+			delete(s.aliasCache.entries, key)
+		}
+	}
+}
+
+// aliasAnswers synthesizes the A or AAAA answers for an ALIAS record.
+func (s *DNSServer) aliasAnswers(resolver Resolver, qname string, target string, qtype uint16) ([]dns.RR, error) {
+	addresses, ttl, err := s.resolveAlias(resolver, target, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var answers []dns.RR
+	for _, address := range addresses {
+		if qtype == dns.TypeA {
+			answers = append(answers, aRecord(qname, ttl, address.String()))
+		} else {
+			answers = append(answers, aaaaRecord(qname, ttl, address.String()))
+		}
+	}
+
+	return answers, nil
+}
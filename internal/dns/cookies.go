@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"time"
+)
+
+const (
+	clientCookieLen = 8
+	serverCookieLen = 16
+	cookieVersion   = 1
+	// Server cookies are valid for an hour and refreshed after half of it,
+	// as recommended by RFC 9018.
+	cookieLifetime = time.Hour
+	cookieRefresh  = 30 * time.Minute
+	cookieMaxSkew  = 5 * time.Minute
+)
+
+// CookieSigner creates and validates DNS server cookies (RFC 7873) in the
+// layout of RFC 9018. The hash is keyed with a secret derived from the master
+// secret for every rotation period, so nodes sharing the master secret accept
+// each other's cookies.
+type CookieSigner struct {
+	master   []byte
+	rotation time.Duration
+}
+
+// NewCookieSigner returns a signer using the hex encoded secret, or a random
+// secret when it is empty.
+func NewCookieSigner(secret string, rotation time.Duration) (*CookieSigner, error) {
+	var master []byte
+
+	if secret != "" {
+		decoded, err := hex.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie secret: %s", err.Error())
+		}
+
+		if len(decoded) < 16 {
+			return nil, fmt.Errorf("cookie secret needs to be at least 16 bytes")
+		}
+
+		master = decoded
+	} else {
+		master = make([]byte, 32)
+		if _, err := rand.Read(master); err != nil {
+			return nil, err
+		}
+	}
+
+	if rotation <= 0 {
+		rotation = 24 * time.Hour
+	}
+
+	return &CookieSigner{master: master, rotation: rotation}, nil
+}
+
+func (c *CookieSigner) periodSecret(timestamp uint32) []byte {
+	period := make([]byte, 8)
+	binary.BigEndian.PutUint64(period, uint64(timestamp)/uint64(c.rotation.Seconds()))
+
+	mac := hmac.New(sha256.New, c.master)
+	mac.Write(period)
+
+	return mac.Sum(nil)
+}
+
+func (c *CookieSigner) serverCookie(client []byte, ip net.IP, timestamp uint32) []byte {
+	cookie := make([]byte, serverCookieLen)
+	cookie[0] = cookieVersion
+	binary.BigEndian.PutUint32(cookie[4:8], timestamp)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	mac := hmac.New(sha256.New, c.periodSecret(timestamp))
+	mac.Write(client)
+	mac.Write(cookie[:8])
+	mac.Write(ip)
+	copy(cookie[8:], mac.Sum(nil)[:8])
+
+	return cookie
+}
+
+// Validate reports whether server is a valid cookie for the client cookie
+// and address, and whether it is old enough to be replaced.
+func (c *CookieSigner) Validate(client []byte, server []byte, ip net.IP) (bool, bool) {
+	if len(server) != serverCookieLen || server[0] != cookieVersion {
+		return false, false
+	}
+
+	timestamp := binary.BigEndian.Uint32(server[4:8])
+	issued := time.Unix(int64(timestamp), 0)
+	now := time.Now()
+
+	if issued.After(now.Add(cookieMaxSkew)) || now.Sub(issued) > cookieLifetime {
+		return false, false
+	}
+
+	if !hmac.Equal(server, c.serverCookie(client, ip, timestamp)) {
+		return false, false
+	}
+
+	return true, now.Sub(issued) > cookieRefresh
+}
+
+// New returns a fresh server cookie for the client cookie and address.
+func (c *CookieSigner) New(client []byte, ip net.IP) []byte {
+	return c.serverCookie(client, ip, uint32(time.Now().Unix()))
+}
+
+// cookieState is what a request told us about its DNS cookie.
+type cookieState struct {
+	client    []byte
+	server    []byte
+	valid     bool
+	malformed bool
+}
+
+// checkCookie parses and validates the COOKIE option of a request.
+func (s *DNSServer) checkCookie(r *dns.Msg, ip net.IP) cookieState {
+	var state cookieState
+
+	opt := r.IsEdns0()
+	if opt == nil || s.cookies == nil {
+		return state
+	}
+
+	for _, option := range opt.Option {
+		cookie, ok := option.(*dns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+
+		raw, err := hex.DecodeString(cookie.Cookie)
+		if err != nil || len(raw) < clientCookieLen || (len(raw) > clientCookieLen && len(raw) < clientCookieLen+8) || len(raw) > clientCookieLen+32 {
+			state.malformed = true
+			return state
+		}
+
+		state.client = raw[:clientCookieLen]
+
+		if len(raw) > clientCookieLen {
+			valid, stale := s.cookies.Validate(state.client, raw[clientCookieLen:], ip)
+			state.valid = valid
+
+			if valid && !stale {
+				state.server = raw[clientCookieLen:]
+			}
+		}
+
+		break
+	}
+
+	if state.client != nil && state.server == nil {
+		state.server = s.cookies.New(state.client, ip)
+	}
+
+	return state
+}
+
+// option returns the COOKIE option to send back, or nil if the client didn't
+// send one.
+func (c cookieState) option() *dns.EDNS0_COOKIE {
+	if c.client == nil {
+		return nil
+	}
+
+	return &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(c.client) + hex.EncodeToString(c.server),
+	}
+}
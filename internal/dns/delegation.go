@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/miekg/dns"
+)
+
+// findDelegation returns the delegation a name in zone z falls under, if any.
+func (s *DNSServer) findDelegation(z *zone, qname string) *model.Delegation {
+	if z == nil {
+		return nil
+	}
+
+	delegation, err := db.Database.FindDelegation(qname)
+	if err != nil || delegation == nil {
+		return nil
+	}
+
+	logger.Log.Debug("Name ", qname, " is delegated at ", delegation.Domain)
+
+	return delegation
+}
+
+// referralRecords returns the NS set of a delegation for the authority
+// section and the glue for nameservers inside the delegated domain.
+func referralRecords(delegation *model.Delegation, ttl uint32) ([]dns.RR, []dns.RR) {
+	var authority, extra []dns.RR
+
+	owner := dns.Fqdn(delegation.Domain)
+
+	for _, server := range delegation.NameServers {
+		name := dns.Fqdn(server.Name)
+
+		authority = append(authority, &dns.NS{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  name,
+		})
+
+		if !dns.IsSubDomain(owner, name) {
+			continue
+		}
+
+		if server.ARecord != "" {
+			extra = append(extra, aRecord(name, ttl, server.ARecord))
+		}
+
+		if server.AAAARecord != "" {
+			extra = append(extra, aaaaRecord(name, ttl, server.AAAARecord))
+		}
+	}
+
+	return authority, extra
+}
@@ -0,0 +1,352 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+const defaultGeoRegion = "default"
+
+// geoRange is a range of addresses of one family, first and last included,
+// mapped to the region of the most specific network covering it.
+type geoRange struct {
+	first  net.IP
+	last   net.IP
+	region string
+}
+
+// GeoMap maps client networks to region names. Lookups return the most
+// specific network containing the address.
+//
+// The networks are flattened into sorted ranges that don't overlap, one list
+// per address family, so lookups are a binary search however large the map.
+type GeoMap struct {
+	v4       []geoRange
+	v6       []geoRange
+	networks int
+}
+
+// LoadGeoMap reads a CSV file of "cidr,region" lines. Empty lines and lines
+// starting with # are skipped.
+func LoadGeoMap(path string) (*GeoMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var v4, v6 []geoRange
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected cidr,region", path, line)
+		}
+
+		_, network, err := net.ParseCIDR(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err.Error())
+		}
+
+		entry := networkRange(network, strings.ToLower(strings.TrimSpace(fields[1])))
+		if len(entry.first) == net.IPv4len {
+			v4 = append(v4, entry)
+		} else {
+			v6 = append(v6, entry)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &GeoMap{
+		v4:       flattenGeoRanges(v4),
+		v6:       flattenGeoRanges(v6),
+		networks: len(v4) + len(v6),
+	}, nil
+}
+
+// networkRange returns the range of addresses of a network, IPv4 networks
+// with 4 byte addresses.
+func networkRange(network *net.IPNet, region string) geoRange {
+	first := network.IP.To16()
+	mask := network.Mask
+	if ip4 := network.IP.To4(); ip4 != nil {
+		first = ip4
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+	}
+
+	first = append(net.IP(nil), first...)
+	last := append(net.IP(nil), first...)
+	for i := range last {
+		last[i] |= ^mask[i]
+	}
+
+	return geoRange{first: first, last: last, region: region}
+}
+
+// flattenGeoRanges turns network ranges into sorted ranges that don't
+// overlap. Networks either nest or don't overlap at all, so walking them
+// outermost first and keeping the open ones on a stack gives every address
+// the innermost network around it. Of networks listed twice, the first wins.
+func flattenGeoRanges(networks []geoRange) []geoRange {
+	sort.SliceStable(networks, func(i, j int) bool {
+		if c := bytes.Compare(networks[i].first, networks[j].first); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(networks[i].last, networks[j].last) > 0
+	})
+
+	var flat, open []geoRange
+	var next net.IP // first address not flattened yet, nil past the end
+
+	emit := func(last net.IP, network geoRange) {
+		if next == nil || bytes.Compare(next, last) > 0 {
+			return
+		}
+
+		flat = append(flat, geoRange{first: next, last: last, region: network.region})
+		next = nextIP(last)
+	}
+
+	for _, network := range networks {
+		for len(open) > 0 && bytes.Compare(open[len(open)-1].last, network.first) < 0 {
+			emit(open[len(open)-1].last, open[len(open)-1])
+			open = open[:len(open)-1]
+		}
+
+		if len(open) > 0 {
+			top := open[len(open)-1]
+			if bytes.Equal(top.first, network.first) && bytes.Equal(top.last, network.last) {
+				continue
+			}
+
+			if prev := prevIP(network.first); prev != nil {
+				emit(prev, top)
+			}
+		}
+
+		next = network.first
+		open = append(open, network)
+	}
+
+	for len(open) > 0 {
+		emit(open[len(open)-1].last, open[len(open)-1])
+		open = open[:len(open)-1]
+	}
+
+	return flat
+}
+
+// nextIP returns the address after ip, or nil after the last one.
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+
+	return nil
+}
+
+// prevIP returns the address before ip, or nil before the first one.
+func prevIP(ip net.IP) net.IP {
+	prev := append(net.IP(nil), ip...)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			return prev
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the region of an address and the ECS scope of the answer:
+// the shortest prefix whose block around the address lies entirely inside
+// the range it was matched by, so a nested network of another region is
+// never covered. Addresses outside the map get the gap between networks.
+func (g *GeoMap) Lookup(ip net.IP) (string, int) {
+	if g == nil || ip == nil {
+		return "", 0
+	}
+
+	ranges := g.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, ranges = ip4, g.v4
+	} else if ip = ip.To16(); ip == nil {
+		return "", 0
+	}
+
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].last, ip) >= 0
+	})
+
+	if i < len(ranges) && bytes.Compare(ranges[i].first, ip) <= 0 {
+		return ranges[i].region, blockPrefix(ip, ranges[i].first, ranges[i].last)
+	}
+
+	// In a gap, bounded by the ranges around it or the address space.
+	first := make(net.IP, len(ip))
+	last := net.IP(bytes.Repeat([]byte{0xff}, len(ip)))
+
+	if i > 0 {
+		first = nextIP(ranges[i-1].last)
+	}
+
+	if i < len(ranges) {
+		last = prevIP(ranges[i].first)
+	}
+
+	return "", blockPrefix(ip, first, last)
+}
+
+// blockPrefix returns the shortest prefix length whose block around ip lies
+// between first and last, at most the length of the address.
+func blockPrefix(ip net.IP, first net.IP, last net.IP) int {
+	bits := 8 * len(ip)
+
+	for ones := 0; ones < bits; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		lo := ip.Mask(mask)
+		hi := append(net.IP(nil), lo...)
+		for i := range hi {
+			hi[i] |= ^mask[i]
+		}
+
+		if bytes.Compare(lo, first) >= 0 && bytes.Compare(hi, last) <= 0 {
+			return ones
+		}
+	}
+
+	return bits
+}
+
+func (g *GeoMap) Len() int {
+	if g == nil {
+		return 0
+	}
+
+	return g.networks
+}
+
+// clientSubnet returns the address used for geo lookups, taken from the EDNS
+// Client Subnet option when present and from the source address otherwise,
+// along with the ECS option itself. An option with a source prefix of 0 asks
+// not to be located by it, so the source address is used instead.
+func clientSubnet(w dns.ResponseWriter, r *dns.Msg) (net.IP, *dns.EDNS0_SUBNET) {
+	var ecs *dns.EDNS0_SUBNET
+
+	if opt := r.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+				ecs = subnet
+				break
+			}
+		}
+	}
+
+	if ecs != nil && ecs.SourceNetmask > 0 {
+		return ecs.Address, ecs
+	}
+
+	ip := remoteIP(w)
+
+	if ip.To4() != nil {
+		return ip.To4(), ecs
+	}
+
+	return ip, ecs
+}
+
+// geoQuery carries the client location of a single query and remembers the
+// scope an answer was tailored to, so it can be echoed in the ECS option.
+type geoQuery struct {
+	ip       net.IP
+	ecs      *dns.EDNS0_SUBNET
+	region   string
+	scope    int
+	resolved bool
+}
+
+func (s *DNSServer) newGeoQuery(w dns.ResponseWriter, r *dns.Msg) *geoQuery {
+	ip, ecs := clientSubnet(w, r)
+
+	return &geoQuery{
+		ip:  ip,
+		ecs: ecs,
+	}
+}
+
+// geoRegion resolves the client's region lazily, as most names don't depend on
+// it. Calling it marks the answer as location dependent, valid for the block
+// of addresses sharing the region. Answers to an ECS option with a source
+// prefix of 0 keep scope 0, as they weren't located by it.
+func (st *settings) geoRegion(q *geoQuery) string {
+	if !q.resolved {
+		q.region, q.scope = st.geo.Lookup(q.ip)
+		q.resolved = true
+
+		if q.ecs != nil && q.ecs.SourceNetmask == 0 {
+			q.scope = 0
+		}
+	}
+
+	return q.region
+}
+
+// geoAddresses returns the addresses configured for a name in the client's
+// region, falling back to the default region.
+func (st *settings) geoAddresses(q *geoQuery, qname string, qtype uint16) ([]string, bool) {
+	regions, ok := st.geoRecords[strings.TrimSuffix(qname, ".")]
+	if !ok {
+		return nil, false
+	}
+
+	set, ok := regions[st.geoRegion(q)]
+	if !ok {
+		set = regions[defaultGeoRegion]
+	}
+
+	if qtype == dns.TypeAAAA {
+		return set.IPv6, true
+	}
+
+	return set.IPv4, true
+}
+
+// ecsResponse builds the ECS option for the response. Scope 0 tells
+// resolvers the answer is valid for every client.
+func (q *geoQuery) ecsResponse() *dns.EDNS0_SUBNET {
+	if q.ecs == nil {
+		return nil
+	}
+
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        q.ecs.Family,
+		SourceNetmask: q.ecs.SourceNetmask,
+		SourceScope:   uint8(q.scope),
+		Address:       q.ecs.Address,
+	}
+}
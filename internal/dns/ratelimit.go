@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const rateLimitPruneSize = 65536
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	slip   int
+}
+
+// RateLimiter is a response rate limiter keyed by client network (/24 for
+// IPv4, /56 for IPv6), allowing a steady rate with bursts of up to one
+// second worth of responses.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	slip    int
+	buckets map[string]*rateBucket
+}
+
+func NewRateLimiter(responsesPerSecond int, slip int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(responsesPerSecond),
+		slip:    slip,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// matches reports whether the limiter enforces the given limits.
+func (l *RateLimiter) matches(responsesPerSecond int, slip int) bool {
+	return l != nil && l.rate == float64(responsesPerSecond) && l.slip == slip
+}
+
+// Allow reports whether a response may be sent to ip. When it may not, slip
+// tells whether a truncated response should be sent instead of dropping it,
+// so legitimate clients can retry over TCP.
+func (l *RateLimiter) Allow(ip net.IP) (bool, bool) {
+	if l == nil || l.rate <= 0 {
+		return true, false
+	}
+
+	key := rateLimitKey(ip)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buckets) >= rateLimitPruneSize {
+		l.prune(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: l.rate, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.rate {
+		bucket.tokens = l.rate
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, false
+	}
+
+	bucket.slip++
+	return false, l.slip > 0 && bucket.slip%l.slip == 0
+}
+
+// prune drops buckets that have been idle long enough to be full again.
+func (l *RateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > time.Second {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func rateLimitKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(56, 128)).String()
+}
@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+// ChaosAnswers holds the TXT answers for CHAOS class server identification
+// queries. Empty answers are refused.
+type ChaosAnswers struct {
+	Version  string
+	Hostname string
+	ID       string
+}
+
+// acceptRequest lets every request through to ServeDNS, which answers the
+// malformed ones itself. Responses are dropped.
+func acceptRequest(dh dns.Header) dns.MsgAcceptAction {
+	if dh.Bits&(1<<15) != 0 {
+		return dns.MsgIgnore
+	}
+
+	return dns.MsgAccept
+}
+
+// validateRequest checks the parts of a request every handler relies on and
+// returns the rcode to reject it with, or RcodeSuccess.
+func validateRequest(r *dns.Msg) int {
+	if r.Opcode != dns.OpcodeQuery {
+		return dns.RcodeNotImplemented
+	}
+
+	if len(r.Question) != 1 {
+		return dns.RcodeFormatError
+	}
+
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		return dns.RcodeRefused
+	}
+
+	switch r.Question[0].Qclass {
+	case dns.ClassINET, dns.ClassCHAOS:
+		return dns.RcodeSuccess
+	default:
+		return dns.RcodeRefused
+	}
+}
+
+// serveChaos answers CHAOS class queries for version.bind, hostname.bind and
+// id.server.
+func (st *settings) serveChaos(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+
+	var text string
+
+	switch name {
+	case "version.bind.", "version.server.":
+		text = st.chaos.Version
+	case "hostname.bind.":
+		text = st.chaos.Hostname
+	case "id.server.":
+		text = st.chaos.ID
+	}
+
+	m := new(dns.Msg)
+
+	if text == "" || (q.Qtype != dns.TypeTXT && q.Qtype != dns.TypeANY) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Answer = append(m.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+		Txt: []string{text},
+	})
+
+	w.WriteMsg(m)
+}
+
+// anyRecord is the minimal answer to ANY queries as described in RFC 8482.
+func anyRecord(qname string) *dns.HINFO {
+	return &dns.HINFO{
+		Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: 3600},
+		Cpu: "RFC8482",
+		Os:  "",
+	}
+}
+
+// writeRcode sends an empty response with rcode, keeping the EDNS and cookie
+// options of the request.
+func (s *DNSServer) writeRcode(w dns.ResponseWriter, r *dns.Msg, rcode int, cookie cookieState) {
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(ednsUDPSize, opt.Do())
+
+		if option := cookie.option(); option != nil {
+			m.IsEdns0().Option = append(m.IsEdns0().Option, option)
+		}
+	}
+
+	w.WriteMsg(m)
+}
+
+// remoteIP returns the transport source address of a request.
+func remoteIP(w dns.ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
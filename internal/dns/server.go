@@ -1,131 +1,403 @@
 package dns
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"github.com/DifuseHQ/dddns/internal/db"
 	"github.com/DifuseHQ/dddns/internal/db/model"
 	"github.com/DifuseHQ/dddns/internal/utils"
+	"github.com/DifuseHQ/dddns/pkg/config"
 	"github.com/DifuseHQ/dddns/pkg/logger"
 	"github.com/miekg/dns"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DNSStatistics counts the queries answered since the server started. The
+// counters are updated atomically; read them through Statistics.
 type DNSStatistics struct {
-	TotalQueries      int64
-	SuccessfulQueries int64
-	FailedQueries     int64
-	SOAQueries        int64
-	NSQueries         int64
-	AQueries          int64
-	AAAAQueries       int64
+	TotalQueries      int64 `json:"total_queries"`
+	SuccessfulQueries int64 `json:"successful_queries"`
+	FailedQueries     int64 `json:"failed_queries"`
+	SOAQueries        int64 `json:"soa_queries"`
+	NSQueries         int64 `json:"ns_queries"`
+	AQueries          int64 `json:"a_queries"`
+	AAAAQueries       int64 `json:"aaaa_queries"`
+	TXTQueries        int64 `json:"txt_queries"`
+	Referrals         int64 `json:"referrals"`
+	RateLimited       int64 `json:"rate_limited"`
+	BadCookies        int64 `json:"bad_cookies"`
 }
 
 type DNSServer struct {
-	addr       string
-	protocol   string
-	nameserver string
-	domain     string
-	mailbox    string
-	StartTime  int64
-	authority  bool
-	Stats      DNSStatistics
-	TunnelA    string
-	TunnelAAAA string
+	StartTime   int64
+	Stats       DNSStatistics
+	settings    atomic.Pointer[settings]
+	tsigSecrets map[string]string
+	aliasCache  *aliasCache
+	cookies     *CookieSigner
+	servers     []*dns.Server
+	nameQueries sync.Map
 }
 
-func (s *DNSServer) InitDNSServer(dnsAddr string, dnsPort string, nameServer string, domain string, mailbox string, authority bool, tunnelA string, tunnelAAAA string) {
-	srv := &dns.Server{
-		Addr:    dnsAddr + ":" + dnsPort,
-		Net:     "udp",
-		Handler: s,
-	}
+// SetCookies enables DNS cookies, signed with signer.
+func (s *DNSServer) SetCookies(signer *CookieSigner) {
+	s.cookies = signer
+}
+
+const ednsUDPSize = 1232
 
-	if !strings.HasSuffix(nameServer, ".") {
-		nameServer = nameServer + "."
+// InitDNSServer applies cfg and sets up the UDP and TCP listeners, which are
+// started by Serve.
+func (s *DNSServer) InitDNSServer(cfg *config.Config) error {
+	s.tsigSecrets = tsigSecrets(cfg.TSIGKeys)
+	s.aliasCache = &aliasCache{entries: make(map[aliasKey]aliasEntry)}
+
+	if err := s.Configure(cfg); err != nil {
+		return err
 	}
 
-	if !strings.HasSuffix(mailbox, ".") {
-		mailbox = mailbox + "."
+	s.servers = nil
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{
+			Addr:          net.JoinHostPort(cfg.DNSAddr, cfg.DNSPort),
+			Net:           network,
+			Handler:       s,
+			MsgAcceptFunc: acceptRequest,
+		}
+
+		if len(s.tsigSecrets) > 0 {
+			srv.TsigSecret = s.tsigSecrets
+		}
+
+		s.servers = append(s.servers, srv)
 	}
 
-	s.nameserver = nameServer
-	s.mailbox = mailbox
-	s.domain = domain
-	s.authority = authority
 	s.StartTime = time.Now().Unix()
-	s.TunnelA = tunnelA
-	s.TunnelAAAA = tunnelAAAA
 
-	if err := srv.ListenAndServe(); err != nil {
-		logger.Log.Fatal("Failed to start DNS server ", err.Error())
+	return nil
+}
+
+func (s *DNSServer) Name() string {
+	return "DNS server"
+}
+
+// Serve runs the listeners until Shutdown is called or one of them fails.
+func (s *DNSServer) Serve() error {
+	errs := make(chan error, len(s.servers))
+
+	for _, srv := range s.servers {
+		go func(srv *dns.Server) {
+			srv.NotifyStartedFunc = func() {
+				logger.Log.Info("DNS server listening on ", srv.Addr, "/", srv.Net)
+			}
+			errs <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	for range s.servers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Shutdown stops the listeners and waits for in-flight queries to be
+// answered, until ctx expires.
+func (s *DNSServer) Shutdown(ctx context.Context) error {
+	var firstErr error
+
+	for _, srv := range s.servers {
+		if err := srv.ShutdownContext(ctx); err != nil && firstErr == nil && ctx.Err() != nil {
+			firstErr = err
+		}
+	}
+
+	logger.Log.Info("DNS statistics at shutdown: ", fmt.Sprintf("%+v", s.Statistics()))
+
+	return firstErr
+}
+
+// Statistics returns a snapshot of the query counters.
+func (s *DNSServer) Statistics() DNSStatistics {
+	return DNSStatistics{
+		TotalQueries:      atomic.LoadInt64(&s.Stats.TotalQueries),
+		SuccessfulQueries: atomic.LoadInt64(&s.Stats.SuccessfulQueries),
+		FailedQueries:     atomic.LoadInt64(&s.Stats.FailedQueries),
+		SOAQueries:        atomic.LoadInt64(&s.Stats.SOAQueries),
+		NSQueries:         atomic.LoadInt64(&s.Stats.NSQueries),
+		AQueries:          atomic.LoadInt64(&s.Stats.AQueries),
+		AAAAQueries:       atomic.LoadInt64(&s.Stats.AAAAQueries),
+		TXTQueries:        atomic.LoadInt64(&s.Stats.TXTQueries),
+		Referrals:         atomic.LoadInt64(&s.Stats.Referrals),
+		RateLimited:       atomic.LoadInt64(&s.Stats.RateLimited),
+		BadCookies:        atomic.LoadInt64(&s.Stats.BadCookies),
+	}
+}
+
+// nameQueryKey identifies the query count of a name while one device holds
+// it, so a name released and taken by another device starts over.
+type nameQueryKey struct {
+	uuid   string
+	domain string
+}
+
+// QueryCount returns how many queries this node answered for a name held by
+// the device of uuid since it started.
+func (s *DNSServer) QueryCount(uuid string, name string) int64 {
+	if count, ok := s.nameQueries.Load(nameQueryKey{uuid, name}); ok {
+		return count.(*atomic.Int64).Load()
+	}
+
+	return 0
+}
+
+func (s *DNSServer) countNameQuery(uuid string, name string) {
+	key := nameQueryKey{uuid, name}
+
+	count, ok := s.nameQueries.Load(key)
+	if !ok {
+		count, _ = s.nameQueries.LoadOrStore(key, &atomic.Int64{})
 	}
+
+	count.(*atomic.Int64).Add(1)
+}
+
+// pruneNameQueries drops the query counts of names their device no longer
+// holds, whichever node released them.
+func (s *DNSServer) pruneNameQueries() {
+	var keys []nameQueryKey
+	var domains []string
+
+	s.nameQueries.Range(func(key, _ interface{}) bool {
+		keys = append(keys, key.(nameQueryKey))
+		domains = append(domains, key.(nameQueryKey).domain)
+		return true
+	})
+
+	if len(keys) == 0 {
+		return
+	}
+
+	holders, err := db.Database.GetRecordHolders(domains)
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		if holders[key.domain] != key.uuid {
+			s.nameQueries.Delete(key)
+		}
+	}
+}
+
+// PruneCaches drops expired cache entries and the query counts of released
+// names, run periodically in the background.
+func (s *DNSServer) PruneCaches() {
+	s.pruneAliasCache()
+	s.pruneNameQueries()
 }
 
 func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Response {
+		return
+	}
+
+	atomic.AddInt64(&s.Stats.TotalQueries, 1)
+
+	if rcode := validateRequest(r); rcode != dns.RcodeSuccess {
+		logger.Log.Debug("Rejecting malformed or unsupported request with Rcode: ", rcode)
+
+		atomic.AddInt64(&s.Stats.FailedQueries, 1)
+
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		w.WriteMsg(m)
+		return
+	}
+
+	st := s.settings.Load()
+	ip := remoteIP(w)
+	cookie := s.checkCookie(r, ip)
+
+	if cookie.malformed {
+		logger.Log.Debug("Rejecting request with malformed cookie from ", ip)
+
+		atomic.AddInt64(&s.Stats.FailedQueries, 1)
+		s.writeRcode(w, r, dns.RcodeFormatError, cookie)
+		return
+	}
+
+	if w.LocalAddr().Network() == "udp" && !cookie.valid {
+		if allowed, slip := st.rateLimiter.Allow(ip); !allowed {
+			atomic.AddInt64(&s.Stats.RateLimited, 1)
+
+			if cookie.client != nil {
+				atomic.AddInt64(&s.Stats.BadCookies, 1)
+				s.writeRcode(w, r, dns.RcodeBadCookie, cookie)
+			} else if slip {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				m.Truncated = true
+				w.WriteMsg(m)
+			}
+
+			return
+		}
+	}
+
+	if r.Question[0].Qclass == dns.ClassCHAOS {
+		st.serveChaos(w, r)
+		return
+	}
+
 	qname := strings.ToLower(dns.Name(r.Question[0].Name).String())
 	qtype := r.Question[0].Qtype
 
 	logger.Log.Debug("Received DNS query: ", qname, " Type: ", qtype)
 
 	var answers []dns.RR
+	var authority []dns.RR
+	var extra []dns.RR
 	var responseCode int
+	var referral bool
+
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() != nil {
+		logger.Log.Debug("TSIG verification failed for ", qname, ": ", w.TsigStatus())
+
+		atomic.AddInt64(&s.Stats.FailedQueries, 1)
+
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
 
-	s.Stats.TotalQueries++
+	gq := s.newGeoQuery(w, r)
+	viewName := st.selectView(w, r)
+	z := st.findZone(qname)
 
-	if utils.DomainEndsWith(qname, fmt.Sprintf(".backname.%s.", s.domain)) || utils.DomainEndsWith(qname, fmt.Sprintf(".backname.%s", s.domain)) {
-		subdomainOnly := strings.TrimSuffix(qname, ".backname.difusedns.com.")
+	if delegation := s.findDelegation(z, qname); delegation != nil {
+		authority, extra = referralRecords(delegation, st.ttl)
+		referral = true
+		responseCode = dns.RcodeSuccess
+		atomic.AddInt64(&s.Stats.Referrals, 1)
+	} else if z != nil && z.backname && utils.DomainEndsWith(qname, ".backname."+z.domain) {
+		subdomainOnly := strings.TrimSuffix(qname, ".backname."+z.domain)
 		if qtype == dns.TypeA {
 			ipAddress := utils.ParseIPv4Subdomain(subdomainOnly)
 			if ipAddress != "" {
-				answers = append(answers, aRecord(qname, 60, ipAddress))
+				answers = append(answers, aRecord(qname, st.ttl, ipAddress))
 				responseCode = dns.RcodeSuccess
-				s.Stats.AQueries++
+				atomic.AddInt64(&s.Stats.AQueries, 1)
 			} else {
 				responseCode = dns.RcodeSuccess
 			}
 		} else if qtype == dns.TypeAAAA {
 			ipAddress := utils.ParseIPv6Subdomain(subdomainOnly)
 			if ipAddress != "" {
-				answers = append(answers, aaaaRecord(qname, 60, ipAddress))
+				answers = append(answers, aaaaRecord(qname, st.ttl, ipAddress))
 				responseCode = dns.RcodeSuccess
-				s.Stats.AAAAQueries++
+				atomic.AddInt64(&s.Stats.AAAAQueries, 1)
 			} else {
 				responseCode = dns.RcodeSuccess
 			}
 		} else {
 			responseCode = dns.RcodeNameError
 		}
-	} else if utils.StringContains(qname, "tunnel.difusedns.com") {
+	} else if z != nil && z.tunnel && utils.DomainEndsWith(qname, ".tunnel."+z.domain) {
+		tunnelA, tunnelAAAA := st.tunnelTarget(gq, z, qname)
+
 		if qtype == dns.TypeA {
-			answers = append(answers, aRecord(qname, 60, s.TunnelA))
+			for _, address := range tunnelA {
+				answers = append(answers, aRecord(qname, st.ttl, address))
+			}
 			responseCode = dns.RcodeSuccess
-			s.Stats.AQueries++
+			atomic.AddInt64(&s.Stats.AQueries, 1)
 		} else if qtype == dns.TypeAAAA {
-			answers = append(answers, aaaaRecord(qname, 60, s.TunnelAAAA))
+			for _, address := range tunnelAAAA {
+				answers = append(answers, aaaaRecord(qname, st.ttl, address))
+			}
 			responseCode = dns.RcodeSuccess
-			s.Stats.AAAAQueries++
+			atomic.AddInt64(&s.Stats.AAAAQueries, 1)
 		} else {
 			responseCode = dns.RcodeNameError
 		}
+	} else if ns := z.nameserverByName(qname); ns != nil && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		if qtype == dns.TypeA && ns.ipv4 != "" {
+			answers = append(answers, aRecord(qname, st.ttl, ns.ipv4))
+		} else if qtype == dns.TypeAAAA && ns.ipv6 != "" {
+			answers = append(answers, aaaaRecord(qname, st.ttl, ns.ipv6))
+		}
+		responseCode = dns.RcodeSuccess
+		logger.Log.Debug("Nameserver address served for ", qname)
+	} else if addresses, ok := st.geoAddresses(gq, qname, qtype); ok {
+		if qtype == dns.TypeA {
+			for _, address := range addresses {
+				answers = append(answers, aRecord(qname, st.ttl, address))
+			}
+			atomic.AddInt64(&s.Stats.AQueries, 1)
+		} else if qtype == dns.TypeAAAA {
+			for _, address := range addresses {
+				answers = append(answers, aaaaRecord(qname, st.ttl, address))
+			}
+			atomic.AddInt64(&s.Stats.AAAAQueries, 1)
+		}
+		responseCode = dns.RcodeSuccess
+		logger.Log.Debug("Geo record served for ", qname, " in region ", gq.region)
+	} else if z == nil {
+		responseCode = dns.RcodeRefused
+		logger.Log.Debug("Not authoritative for ", qname)
 	} else {
-		record := getRecordFromDB(db.Database, qname)
-		logger.Log.Debug("Queried DB for record: ", qname)
+		record := getRecordFromDB(db.Database, qname, viewName)
+		logger.Log.Debug("Queried DB for record: ", qname, " in view ", viewName)
 
 		if record != nil {
 			logger.Log.Debug("Record found for ", qname)
-			if qtype == dns.TypeA && record.ARecord != "" {
-				answers = append(answers, aRecord(qname, 60, record.ARecord))
+			s.countNameQuery(record.UUID, record.Domain)
+
+			if (qtype == dns.TypeA && record.ARecord == "" || qtype == dns.TypeAAAA && record.AAAARecord == "") && record.Alias != "" {
+				aliasAnswers, err := s.aliasAnswers(st.aliasResolver, qname, record.Alias, qtype)
+				if err != nil {
+					responseCode = dns.RcodeServerFailure
+					logger.Log.Error("Failed to resolve ALIAS target ", record.Alias, " for ", qname, ": ", err.Error())
+				} else {
+					answers = append(answers, aliasAnswers...)
+					responseCode = dns.RcodeSuccess
+					logger.Log.Debug("ALIAS ", record.Alias, " resolved for ", qname)
+				}
+
+				if qtype == dns.TypeA {
+					atomic.AddInt64(&s.Stats.AQueries, 1)
+				} else {
+					atomic.AddInt64(&s.Stats.AAAAQueries, 1)
+				}
+			} else if qtype == dns.TypeA && record.ARecord != "" {
+				answers = append(answers, aRecord(qname, st.ttl, record.ARecord))
 				responseCode = dns.RcodeSuccess
-				s.Stats.AQueries++
+				atomic.AddInt64(&s.Stats.AQueries, 1)
 				logger.Log.Debug("A record found for ", qname)
 			} else if qtype == dns.TypeAAAA && record.AAAARecord != "" {
-				answers = append(answers, aaaaRecord(qname, 60, record.AAAARecord))
+				answers = append(answers, aaaaRecord(qname, st.ttl, record.AAAARecord))
 				responseCode = dns.RcodeSuccess
-				s.Stats.AAAAQueries++
+				atomic.AddInt64(&s.Stats.AAAAQueries, 1)
 				logger.Log.Debug("AAAA record found for ", qname)
+			} else if qtype == dns.TypeTXT {
+				values, err := db.Database.GetTXTRecords(record.Domain)
+				if err != nil {
+					responseCode = dns.RcodeServerFailure
+				} else {
+					for _, value := range values {
+						answers = append(answers, txtRecord(qname, st.ttl, value))
+					}
+					responseCode = dns.RcodeSuccess
+				}
+				atomic.AddInt64(&s.Stats.TXTQueries, 1)
 			} else {
 				responseCode = dns.RcodeSuccess
 				logger.Log.Debug("No matching record type found for ", qname)
@@ -136,42 +408,69 @@ func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		}
 	}
 
-	if qtype == dns.TypeSOA {
-		soaR := soaRecord(s, qname)
-		if soaR == nil {
-			responseCode = dns.RcodeNameError
-			logger.Log.Debug("No SOA record found for ", qname)
-		} else {
-			answers = append(answers, soaR)
-			responseCode = dns.RcodeSuccess
+	if z == nil || referral {
+		// Nothing to add for names outside our zones or delegated away
+	} else if qtype == dns.TypeSOA {
+		if qname == z.domain {
+			answers = append(answers, soaRecord(z, st.ttl))
 			logger.Log.Debug("SOA record appended for ", qname)
 		}
-		s.Stats.SOAQueries++
+		responseCode = dns.RcodeSuccess
+		atomic.AddInt64(&s.Stats.SOAQueries, 1)
+	} else if qtype == dns.TypeANY {
+		answers = []dns.RR{anyRecord(qname)}
+		responseCode = dns.RcodeSuccess
 	} else if qtype == dns.TypeNS {
-		nsR := nsRecord(s, qname)
-		if nsR == nil {
-			responseCode = dns.RcodeNameError
-			logger.Log.Debug("No NS record found for ", qname)
-		} else {
-			answers = append(answers, nsR)
-			responseCode = dns.RcodeSuccess
-			logger.Log.Debug("NS record appended for ", qname)
+		if qname == z.domain {
+			answers = append(answers, nsRecords(z, st.ttl)...)
+			logger.Log.Debug("NS records appended for ", qname)
+		}
+		responseCode = dns.RcodeSuccess
+		atomic.AddInt64(&s.Stats.NSQueries, 1)
+	}
+
+	if z != nil && !referral && (responseCode == dns.RcodeSuccess || responseCode == dns.RcodeNameError) {
+		if len(answers) == 0 {
+			authority = append(authority, soaRecord(z, st.ttl))
+		} else if qtype != dns.TypeNS {
+			authority = append(authority, nsRecords(z, st.ttl)...)
+		}
+
+		if len(answers) > 0 {
+			extra = append(extra, glueRecords(z, answers, qname, st.ttl)...)
 		}
-		s.Stats.NSQueries++
 	}
 
 	if responseCode == dns.RcodeSuccess {
-		s.Stats.SuccessfulQueries++
+		atomic.AddInt64(&s.Stats.SuccessfulQueries, 1)
 	} else {
-		s.Stats.FailedQueries++
+		atomic.AddInt64(&s.Stats.FailedQueries, 1)
 	}
 
 	m := new(dns.Msg)
 	m.SetReply(r)
-	m.Authoritative = s.authority
+	m.Authoritative = st.authority && responseCode != dns.RcodeRefused && !referral
 	m.Answer = answers
+	m.Ns = authority
+	m.Extra = extra
 	m.Rcode = responseCode
 
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(ednsUDPSize, opt.Do())
+
+		if ecs := gq.ecsResponse(); ecs != nil {
+			m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+		}
+
+		if option := cookie.option(); option != nil {
+			m.IsEdns0().Option = append(m.IsEdns0().Option, option)
+		}
+	}
+
+	if tsig := r.IsTsig(); tsig != nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+
 	w.WriteMsg(m)
 
 	logger.Log.Debug("Response sent for ", qname, " with Rcode: ", responseCode)
@@ -191,63 +490,159 @@ func aaaaRecord(name string, ttl uint32, ipAddress string) *dns.AAAA {
 	}
 }
 
-func soaRecord(s *DNSServer, qname string) *dns.SOA {
-	serial := utils.GenerateSerial()
-	refresh := 3600
-	retry := 600
-	expire := 1209600
-	minTTL := 300
+// txtRecord returns a TXT record of value, split into strings of at most 255
+// bytes as DNS requires.
+func txtRecord(name string, ttl uint32, value string) *dns.TXT {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
 
-	if !strings.HasSuffix(qname, ".") {
-		qname = qname + "."
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		Txt: append(parts, value),
 	}
+}
 
-	if utils.StringContains(qname, s.domain) {
-		return &dns.SOA{
-			Hdr:     dns.RR_Header{Name: qname, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
-			Ns:      s.nameserver,
-			Mbox:    s.mailbox,
-			Serial:  serial,
-			Refresh: uint32(refresh),
-			Retry:   uint32(retry),
-			Expire:  uint32(expire),
-			Minttl:  uint32(minTTL),
-		}
-	} else {
-		return nil
+// soaRecord returns the SOA record of a zone, with the serial kept by the
+// store so every node sharing it hands out the same one.
+func soaRecord(z *zone, ttl uint32) *dns.SOA {
+	serial, err := db.Database.ZoneSerial(strings.TrimSuffix(z.domain, "."))
+	if err != nil {
+		serial = utils.GenerateSerial()
+	}
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: z.domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      z.nameservers[0].name,
+		Mbox:    z.mailbox,
+		Serial:  serial,
+		Refresh: z.refresh,
+		Retry:   z.retry,
+		Expire:  z.expire,
+		Minttl:  z.minTTL,
 	}
 }
 
-func nsRecord(s *DNSServer, qname string) *dns.NS {
-	if utils.StringContains(qname, s.domain) {
-		return &dns.NS{
-			Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
-			Ns:  s.nameserver,
-		}
-	} else {
-		return nil
+func nsRecords(z *zone, ttl uint32) []dns.RR {
+	var records []dns.RR
+
+	for _, ns := range z.nameservers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: z.domain, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  ns.name,
+		})
 	}
+
+	return records
 }
 
-func getRecordFromDB(database *sql.DB, domain string) *model.Record {
-	record := &model.Record{}
+// glueRecords returns the addresses of the zone's nameservers that live inside
+// the zone, leaving out what the answer already holds.
+func glueRecords(z *zone, answers []dns.RR, qname string, ttl uint32) []dns.RR {
+	var records []dns.RR
 
+	for _, ns := range z.nameservers {
+		if ns.name == qname || !dns.IsSubDomain(z.domain, ns.name) {
+			continue
+		}
+
+		if ns.ipv4 != "" {
+			records = append(records, aRecord(ns.name, ttl, ns.ipv4))
+		}
+
+		if ns.ipv6 != "" {
+			records = append(records, aaaaRecord(ns.name, ttl, ns.ipv6))
+		}
+	}
+
+	return records
+}
+
+func getRecordFromDB(store db.Store, domain string, viewName string) *model.Record {
 	if strings.HasSuffix(domain, ".") {
 		domain = domain[:len(domain)-1]
 	}
 
-	query := `SELECT domain, a_record, aaaa_record FROM records WHERE domain = ?`
+	record, err := store.GetRecordByDomain(domain)
+	if err != nil || record == nil {
+		logger.Log.Debug("No record found for domain", domain)
+		return nil
+	}
+
+	if viewName != "" {
+		override, err := store.GetRecordView(domain, viewName)
+		if err == nil && override != nil {
+			logger.Log.Debug("Applying view ", viewName, " override for ", domain)
 
-	err := database.QueryRow(query, domain).Scan(&record.Domain, &record.ARecord, &record.AAAARecord)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			logger.Log.Debug("No record found for domain", domain)
-			return nil
-		}
+			if override.ARecord != "" {
+				record.ARecord = override.ARecord
+			}
 
-		logger.Log.Error("Error querying database", err)
-		return nil
+			if override.AAAARecord != "" {
+				record.AAAARecord = override.AAAARecord
+			}
+		}
 	}
 
 	return record
 }
+
+// tunnelTarget returns the relay addresses assigned to a tunnel name. Names
+// without an assignment get the enabled relays of the client's region, or the
+// tunnel addresses of the zone when there are none.
+func (st *settings) tunnelTarget(gq *geoQuery, z *zone, qname string) ([]string, []string) {
+	var tunnelA, tunnelAAAA []string
+
+	relay, err := db.Database.GetTunnelTarget(strings.TrimSuffix(qname, "."))
+	if err == nil && relay != nil {
+		logger.Log.Debug("Tunnel ", qname, " served by relay ", relay.Name)
+
+		if relay.ARecord != "" {
+			tunnelA = append(tunnelA, relay.ARecord)
+		}
+
+		if relay.AAAARecord != "" {
+			tunnelAAAA = append(tunnelAAAA, relay.AAAARecord)
+		}
+
+		return tunnelA, tunnelAAAA
+	}
+
+	if st.geo.Len() > 0 {
+		if region := st.geoRegion(gq); region != "" {
+			relays, err := db.Database.GetTunnelRelays(region)
+			if err == nil {
+				for _, relay := range relays {
+					if !relay.Enabled {
+						continue
+					}
+
+					if relay.ARecord != "" {
+						tunnelA = append(tunnelA, relay.ARecord)
+					}
+
+					if relay.AAAARecord != "" {
+						tunnelAAAA = append(tunnelAAAA, relay.AAAARecord)
+					}
+				}
+			}
+
+			if len(tunnelA) > 0 || len(tunnelAAAA) > 0 {
+				logger.Log.Debug("Tunnel ", qname, " served by relays of region ", region)
+				return tunnelA, tunnelAAAA
+			}
+		}
+	}
+
+	if z.tunnelA != "" {
+		tunnelA = append(tunnelA, z.tunnelA)
+	}
+
+	if z.tunnelAAAA != "" {
+		tunnelAAAA = append(tunnelAAAA, z.tunnelAAAA)
+	}
+
+	return tunnelA, tunnelAAAA
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"strings"
+	"time"
+)
+
+// settings is everything queries are answered from that can change while the
+// server is running. It is replaced as a whole, so a query sees either the old
+// or the new configuration but never a mix of both.
+type settings struct {
+	zones         []*zone
+	authority     bool
+	ttl           uint32
+	geo           *GeoMap
+	geoRecords    map[string]map[string]config.GeoAddressSet
+	views         []view
+	aliasUpstream string
+	aliasResolver Resolver
+	chaos         ChaosAnswers
+	rateLimiter   *RateLimiter
+}
+
+// Configure builds the answer settings from cfg and swaps them in at once.
+// When cfg can't be applied, the current settings are kept and the error is
+// returned. TSIG keys and cookies are only set up by InitDNSServer.
+func (s *DNSServer) Configure(cfg *config.Config) error {
+	current := s.settings.Load()
+
+	next := &settings{
+		authority: cfg.Authoritative,
+		ttl:       uint32(cfg.RecordTTL),
+		chaos: ChaosAnswers{
+			Version:  cfg.ChaosVersion,
+			Hostname: cfg.ChaosHostname,
+			ID:       cfg.ChaosID,
+		},
+	}
+
+	for _, z := range cfg.GetZones() {
+		next.zones = append(next.zones, newZone(z))
+	}
+
+	if cfg.GeoMapPath != "" {
+		geoMap, err := LoadGeoMap(cfg.GeoMapPath)
+		if err != nil {
+			return fmt.Errorf("loading GeoDNS map: %s", err.Error())
+		}
+
+		logger.Log.Info("Loaded ", geoMap.Len(), " GeoDNS networks")
+		next.geo = geoMap
+	}
+
+	next.setGeoRecords(cfg.GeoRecords)
+
+	if err := next.setViews(cfg.Views, s.tsigSecrets); err != nil {
+		return err
+	}
+
+	next.aliasUpstream = cfg.AliasUpstream
+	if current != nil && current.aliasUpstream == cfg.AliasUpstream {
+		next.aliasResolver = current.aliasResolver
+	} else {
+		if cfg.AliasUpstream != "" {
+			next.aliasResolver = NewUpstreamResolver(cfg.AliasUpstream, 2*time.Second)
+		}
+		s.aliasCache.clear()
+	}
+
+	// Keep the buckets of a limiter whose limits didn't change, so a reload
+	// doesn't hand every client a fresh burst.
+	if current != nil && current.rateLimiter.matches(cfg.RRLRate, cfg.RRLSlip) {
+		next.rateLimiter = current.rateLimiter
+	} else {
+		next.rateLimiter = NewRateLimiter(cfg.RRLRate, cfg.RRLSlip)
+	}
+
+	s.settings.Store(next)
+
+	return nil
+}
+
+// setGeoRecords stores the geo records with lowercased names and regions.
+func (st *settings) setGeoRecords(records map[string]map[string]config.GeoAddressSet) {
+	st.geoRecords = make(map[string]map[string]config.GeoAddressSet)
+
+	for name, regions := range records {
+		lowered := make(map[string]config.GeoAddressSet)
+		for region, set := range regions {
+			lowered[strings.ToLower(region)] = set
+		}
+		st.geoRecords[strings.ToLower(strings.TrimSuffix(name, "."))] = lowered
+	}
+}
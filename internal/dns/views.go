@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+type view struct {
+	name     string
+	networks []*net.IPNet
+	tsigKeys map[string]bool
+}
+
+// tsigSecrets returns the TSIG secrets keyed by fully qualified key name, as
+// the listeners expect them.
+func tsigSecrets(tsigKeys map[string]string) map[string]string {
+	secrets := make(map[string]string)
+
+	for name, secret := range tsigKeys {
+		secrets[dns.Fqdn(strings.ToLower(name))] = secret
+	}
+
+	return secrets
+}
+
+// setViews configures split-horizon views. A query is served from the first
+// view whose TSIG keys signed it or whose networks contain its source
+// address, and from the default view otherwise.
+func (st *settings) setViews(views []config.View, secrets map[string]string) error {
+	st.views = nil
+
+	for _, cfgView := range views {
+		if cfgView.Name == "" {
+			return fmt.Errorf("view without a name")
+		}
+
+		v := view{
+			name:     cfgView.Name,
+			tsigKeys: make(map[string]bool),
+		}
+
+		for _, cidr := range cfgView.Networks {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("view %s: %s", cfgView.Name, err.Error())
+			}
+			v.networks = append(v.networks, network)
+		}
+
+		for _, key := range cfgView.TSIGKeys {
+			key = dns.Fqdn(strings.ToLower(key))
+			if _, ok := secrets[key]; !ok {
+				return fmt.Errorf("view %s: unknown TSIG key %s", cfgView.Name, key)
+			}
+			v.tsigKeys[key] = true
+		}
+
+		st.views = append(st.views, v)
+	}
+
+	return nil
+}
+
+// selectView returns the name of the view a query is answered from, empty
+// for the default view. Only the transport source address is considered, a
+// client supplied ECS option can't move a query into another view.
+func (st *settings) selectView(w dns.ResponseWriter, r *dns.Msg) string {
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		key := strings.ToLower(tsig.Hdr.Name)
+		for _, v := range st.views {
+			if v.tsigKeys[key] {
+				return v.name
+			}
+		}
+	}
+
+	ip := remoteIP(w)
+
+	for _, v := range st.views {
+		for _, network := range v.networks {
+			if network.Contains(ip) {
+				return v.name
+			}
+		}
+	}
+
+	return ""
+}
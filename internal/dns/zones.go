@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+type nameserver struct {
+	name string
+	ipv4 string
+	ipv6 string
+}
+
+type zone struct {
+	domain      string
+	nameservers []nameserver
+	mailbox     string
+	refresh     uint32
+	retry       uint32
+	expire      uint32
+	minTTL      uint32
+	backname    bool
+	tunnel      bool
+	tunnelA     string
+	tunnelAAAA  string
+}
+
+func newZone(cfg config.Zone) *zone {
+	var nameservers []nameserver
+	for _, ns := range cfg.NameServers {
+		nameservers = append(nameservers, nameserver{
+			name: dns.Fqdn(strings.ToLower(ns.Name)),
+			ipv4: ns.IPv4,
+			ipv6: ns.IPv6,
+		})
+	}
+
+	return &zone{
+		domain:      dns.Fqdn(strings.ToLower(cfg.Domain)),
+		nameservers: nameservers,
+		mailbox:     dns.Fqdn(strings.ToLower(cfg.MailBox)),
+		refresh:     cfg.SOARefresh,
+		retry:       cfg.SOARetry,
+		expire:      cfg.SOAExpire,
+		minTTL:      cfg.SOAMinTTL,
+		backname:    cfg.Backname,
+		tunnel:      cfg.Tunnel,
+		tunnelA:     cfg.TunnelARecord,
+		tunnelAAAA:  cfg.TunnelAAAARecord,
+	}
+}
+
+// findZone returns the most specific zone a fully qualified name falls in.
+func (st *settings) findZone(qname string) *zone {
+	var found *zone
+
+	for _, z := range st.zones {
+		if dns.IsSubDomain(z.domain, qname) && (found == nil || len(z.domain) > len(found.domain)) {
+			found = z
+		}
+	}
+
+	return found
+}
+
+// nameserverByName returns the nameserver of the zone with the given name.
+func (z *zone) nameserverByName(qname string) *nameserver {
+	if z == nil {
+		return nil
+	}
+
+	for i := range z.nameservers {
+		if z.nameservers[i].name == qname {
+			return &z.nameservers[i]
+		}
+	}
+
+	return nil
+}
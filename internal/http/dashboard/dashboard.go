@@ -0,0 +1,32 @@
+// Package dashboard serves the web dashboard for admins. It is a static
+// page embedded into the binary that reads everything it shows from the admin
+// API, so it needs the admin token or a client certificate like the API does.
+package dashboard
+
+import (
+	"embed"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard, mounted with Use below /dashboard. Scripts
+// and styles may only come from the dashboard itself.
+func Handler() fiber.Handler {
+	files := filesystem.New(filesystem.Config{
+		Root:       http.FS(static),
+		PathPrefix: "static",
+		Index:      "index.html",
+	})
+
+	return func(c *fiber.Ctx) error {
+		c.Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'")
+		c.Set("X-Content-Type-Options", "nosniff")
+		c.Set("Referrer-Policy", "no-referrer")
+
+		return files(c)
+	}
+}
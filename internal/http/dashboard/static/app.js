@@ -0,0 +1,493 @@
+'use strict';
+
+// The dashboard only talks to the admin API. The admin token is kept for the
+// browser session; on the admin TLS listener a client certificate may
+// authenticate instead, so requests are first tried without a token.
+
+const POLL_INTERVAL = 2000;
+const CHART_POINTS = 150;
+const PER_PAGE = 50;
+
+const state = {
+	token: sessionStorage.getItem('dddns-admin-token') || '',
+	tab: 'overview',
+	samples: [],
+	lastStats: null,
+	pollTimer: null,
+	recordsPage: 1,
+	auditPage: 1,
+	detailDomain: '',
+};
+
+const $ = (id) => document.getElementById(id);
+
+class AuthError extends Error {}
+
+async function api(path, options = {}) {
+	const headers = Object.assign({}, options.headers);
+	if (state.token) {
+		headers.Authorization = 'Bearer ' + state.token;
+	}
+
+	const res = await fetch(path, Object.assign({}, options, { headers }));
+	if (res.status === 401 || res.status === 403) {
+		throw new AuthError('Not signed in');
+	}
+
+	const body = await res.json().catch(() => ({}));
+	if (!res.ok && res.status !== 503) {
+		throw new Error(body.error || res.statusText);
+	}
+
+	return body;
+}
+
+function el(tag, props = {}, ...children) {
+	const node = document.createElement(tag);
+	for (const [key, value] of Object.entries(props)) {
+		if (key === 'className') {
+			node.className = value;
+		} else if (key.startsWith('on')) {
+			node.addEventListener(key.slice(2), value);
+		} else {
+			node.setAttribute(key, value);
+		}
+	}
+	for (const child of children) {
+		node.append(child instanceof Node ? child : document.createTextNode(child == null ? '' : String(child)));
+	}
+	return node;
+}
+
+function card(label, value, status) {
+	return el('div', { className: 'card' + (status ? ' ' + status : '') },
+		el('div', { className: 'label' }, label),
+		el('div', { className: 'value' }, value));
+}
+
+function formatTime(value) {
+	if (!value) {
+		return '';
+	}
+	return new Date(value).toLocaleString();
+}
+
+function formatValue(value) {
+	if (!value) {
+		return el('span', { className: 'muted' }, 'none');
+	}
+	const parts = [];
+	if (value.ipv4) parts.push('A ' + value.ipv4);
+	if (value.ipv6) parts.push('AAAA ' + value.ipv6);
+	if (value.alias) parts.push('ALIAS ' + value.alias);
+	for (const view of value.views || []) {
+		parts.push('view ' + view.view + ': ' + [view.ipv4, view.ipv6].filter(Boolean).join(' '));
+	}
+	return parts.join('\n') || '(empty)';
+}
+
+function showError(err) {
+	if (err instanceof AuthError) {
+		signOut();
+		return;
+	}
+	$('error').textContent = err ? err.message : '';
+}
+
+// Sign in
+
+function signOut() {
+	state.token = '';
+	sessionStorage.removeItem('dddns-admin-token');
+	clearInterval(state.pollTimer);
+	state.pollTimer = null;
+
+	for (const section of document.querySelectorAll('.tab')) {
+		section.hidden = true;
+	}
+	$('tabs').hidden = true;
+	$('logout').hidden = true;
+	$('login').hidden = false;
+}
+
+async function signIn() {
+	try {
+		await api('/admin/health');
+	} catch (err) {
+		if (err instanceof AuthError) {
+			signOut();
+			return;
+		}
+	}
+
+	$('login').hidden = true;
+	$('tabs').hidden = false;
+	$('logout').hidden = false;
+	showTab(state.tab);
+}
+
+$('login-form').addEventListener('submit', async (event) => {
+	event.preventDefault();
+	state.token = $('login-token').value;
+
+	try {
+		await api('/admin/health');
+	} catch (err) {
+		$('login-error').textContent = err instanceof AuthError ? 'Invalid token' : err.message;
+		state.token = '';
+		return;
+	}
+
+	sessionStorage.setItem('dddns-admin-token', state.token);
+	$('login-token').value = '';
+	$('login-error').textContent = '';
+	signIn();
+});
+
+$('logout').addEventListener('click', signOut);
+
+// Tabs
+
+function showTab(name) {
+	state.tab = name;
+	for (const button of document.querySelectorAll('#tabs button')) {
+		button.classList.toggle('active', button.dataset.tab === name);
+	}
+	for (const section of document.querySelectorAll('.tab')) {
+		section.hidden = section.id !== name;
+	}
+	showError(null);
+
+	clearInterval(state.pollTimer);
+	state.pollTimer = null;
+
+	if (name === 'overview') {
+		pollOverview();
+		state.pollTimer = setInterval(pollOverview, POLL_INTERVAL);
+	} else if (name === 'records') {
+		loadRecords();
+	} else if (name === 'audit') {
+		loadAudit();
+	} else if (name === 'config') {
+		loadConfig();
+	}
+}
+
+for (const button of document.querySelectorAll('#tabs button')) {
+	button.addEventListener('click', () => showTab(button.dataset.tab));
+}
+
+// Overview
+
+async function pollOverview() {
+	try {
+		const [stats, health, identity] = await Promise.all([
+			api('/admin/stats'),
+			api('/admin/health'),
+			api('/admin/identity-stats'),
+		]);
+		updateRates(stats);
+		renderHealth(health);
+		renderCounters(stats);
+		renderIdentity(identity);
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+function updateRates(stats) {
+	const now = Date.now();
+	const last = state.lastStats;
+
+	// A restarted server starts counting from zero again.
+	if (last && stats.dns.total_queries >= last.dns.total_queries) {
+		const seconds = (now - last.at) / 1000;
+		state.samples.push({
+			total: (stats.dns.total_queries - last.dns.total_queries) / seconds,
+			failed: (stats.dns.failed_queries - last.dns.failed_queries) / seconds,
+			limited: (stats.dns.rate_limited - last.dns.rate_limited) / seconds,
+		});
+		if (state.samples.length > CHART_POINTS) {
+			state.samples.shift();
+		}
+	}
+
+	state.lastStats = Object.assign({ at: now }, stats);
+	drawChart();
+}
+
+function drawChart() {
+	const canvas = $('rate-chart');
+	const ratio = window.devicePixelRatio || 1;
+	const width = canvas.clientWidth;
+	const height = canvas.clientHeight;
+
+	canvas.width = width * ratio;
+	canvas.height = height * ratio;
+
+	const ctx = canvas.getContext('2d');
+	ctx.scale(ratio, ratio);
+	ctx.clearRect(0, 0, width, height);
+
+	const pad = { left: 48, right: 12, top: 12, bottom: 24 };
+	const plotWidth = width - pad.left - pad.right;
+	const plotHeight = height - pad.top - pad.bottom;
+
+	let max = 1;
+	for (const sample of state.samples) {
+		max = Math.max(max, sample.total);
+	}
+	max = niceCeil(max);
+
+	ctx.font = '11px system-ui, sans-serif';
+	ctx.fillStyle = '#6b7385';
+	ctx.strokeStyle = '#eceef2';
+	ctx.lineWidth = 1;
+
+	for (let i = 0; i <= 4; i++) {
+		const y = pad.top + plotHeight - (plotHeight * i) / 4;
+		ctx.beginPath();
+		ctx.moveTo(pad.left, y);
+		ctx.lineTo(width - pad.right, y);
+		ctx.stroke();
+		ctx.fillText(formatRate((max * i) / 4), 4, y + 4);
+	}
+
+	const seconds = (CHART_POINTS * POLL_INTERVAL) / 1000;
+	ctx.fillText('-' + seconds + 's', pad.left, height - 6);
+	ctx.fillText('now', width - pad.right - 20, height - 6);
+
+	const series = [['total', '#4c8bf5'], ['failed', '#d64545'], ['limited', '#e0a020']];
+	for (const [key, color] of series) {
+		ctx.strokeStyle = color;
+		ctx.lineWidth = 2;
+		ctx.beginPath();
+
+		const offset = CHART_POINTS - state.samples.length;
+		state.samples.forEach((sample, i) => {
+			const x = pad.left + (plotWidth * (offset + i)) / (CHART_POINTS - 1);
+			const y = pad.top + plotHeight - (plotHeight * sample[key]) / max;
+			if (i === 0) {
+				ctx.moveTo(x, y);
+			} else {
+				ctx.lineTo(x, y);
+			}
+		});
+		ctx.stroke();
+	}
+}
+
+function niceCeil(value) {
+	const magnitude = Math.pow(10, Math.floor(Math.log10(value)));
+	for (const step of [1, 2, 5, 10]) {
+		if (value <= step * magnitude) {
+			return step * magnitude;
+		}
+	}
+	return 10 * magnitude;
+}
+
+function formatRate(value) {
+	return value >= 10 ? Math.round(value).toString() : value.toFixed(1);
+}
+
+function renderHealth(health) {
+	const cards = [card('Overall', health.status, health.status)];
+	for (const check of health.checks) {
+		cards.push(card(check.name, check.detail ? check.status + ' (' + check.detail + ')' : check.status, check.status));
+	}
+	$('health').replaceChildren(...cards);
+}
+
+function renderCounters(stats) {
+	const dns = stats.dns;
+	$('counters').replaceChildren(
+		card('Uptime', formatDuration(stats.uptime_seconds)),
+		card('Total', dns.total_queries),
+		card('Successful', dns.successful_queries),
+		card('Failed', dns.failed_queries),
+		card('A', dns.a_queries),
+		card('AAAA', dns.aaaa_queries),
+		card('TXT', dns.txt_queries),
+		card('SOA', dns.soa_queries),
+		card('NS', dns.ns_queries),
+		card('Referrals', dns.referrals),
+		card('Rate limited', dns.rate_limited),
+		card('Bad cookies', dns.bad_cookies),
+	);
+}
+
+function renderIdentity(identity) {
+	$('identity').replaceChildren(
+		card('Circuit', identity.circuit_state, identity.circuit_state === 'closed' ? 'ok' : 'degraded'),
+		card('Cache hit rate', (identity.cache_hit_rate * 100).toFixed(1) + '%'),
+		card('Cached UUIDs', identity.cache_size),
+		card('Upstream requests', identity.upstream_requests),
+		card('Upstream errors', identity.upstream_errors),
+		card('Average latency', identity.upstream_latency_avg_ms.toFixed(1) + ' ms'),
+		card('Fail-open accepts', identity.fail_open_accepts),
+	);
+}
+
+function formatDuration(seconds) {
+	const days = Math.floor(seconds / 86400);
+	const hours = Math.floor((seconds % 86400) / 3600);
+	const minutes = Math.floor((seconds % 3600) / 60);
+	return (days ? days + 'd ' : '') + hours + 'h ' + minutes + 'm';
+}
+
+// Records
+
+async function loadRecords() {
+	const params = new URLSearchParams({
+		q: $('search-q').value.trim(),
+		zone: $('search-zone').value.trim(),
+		uuid: $('search-uuid').value.trim(),
+		page: state.recordsPage,
+		per_page: PER_PAGE,
+	});
+
+	try {
+		const result = await api('/admin/records?' + params);
+		const rows = result.records.map((record) => el('tr', {
+			className: 'clickable',
+			onclick: () => showRecord(record.Domain, record.UUID),
+		},
+		el('td', {}, record.Domain),
+		el('td', {}, el('code', {}, record.UUID)),
+		el('td', {}, record.ARecord),
+		el('td', {}, record.AAAARecord),
+		el('td', {}, record.Alias),
+		el('td', {}, formatTime(record.LastUpdateAt))));
+
+		$('records-body').replaceChildren(...rows);
+		renderPager('records', result);
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+function renderPager(name, result) {
+	const pages = Math.max(1, Math.ceil(result.total / result.per_page));
+	$(name + '-page').textContent = 'Page ' + result.page + ' of ' + pages + ' (' + result.total + ')';
+	$(name + '-prev').disabled = result.page <= 1;
+	$(name + '-next').disabled = result.page >= pages;
+}
+
+$('search-form').addEventListener('submit', (event) => {
+	event.preventDefault();
+	state.recordsPage = 1;
+	loadRecords();
+});
+
+$('records-prev').addEventListener('click', () => {
+	state.recordsPage--;
+	loadRecords();
+});
+
+$('records-next').addEventListener('click', () => {
+	state.recordsPage++;
+	loadRecords();
+});
+
+async function showRecord(domain, uuid) {
+	state.detailDomain = domain;
+	$('detail-title').textContent = domain;
+	$('record-detail').hidden = false;
+
+	try {
+		const [history, device] = await Promise.all([
+			api('/admin/records/' + encodeURIComponent(domain) + '/history'),
+			api('/admin/devices/' + encodeURIComponent(uuid)),
+		]);
+
+		$('detail-device').replaceChildren(
+			card('Device', uuid),
+			card('Plan', device.device.plan || 'default'),
+			card('Names', device.records.length + ' of ' + device.quota),
+			card('Active tokens', device.tokens.length),
+			card('Delegations', device.delegations.length),
+			card('Signing key', device.has_signing_key ? 'yes' : 'no'),
+		);
+
+		const rows = history.history.map((entry) => el('tr', {},
+			el('td', {}, formatTime(entry.created_at)),
+			el('td', {}, entry.action),
+			el('td', {}, el('code', {}, entry.uuid)),
+			el('td', {}, el('code', {}, formatValue(entry.old_value))),
+			el('td', {}, el('code', {}, formatValue(entry.new_value))),
+			el('td', {}, entry.actor),
+			el('td', {}, entry.source_ip)));
+
+		$('history-body').replaceChildren(...rows);
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+$('detail-close').addEventListener('click', () => {
+	$('record-detail').hidden = true;
+});
+
+$('detail-release').addEventListener('click', async () => {
+	const domain = state.detailDomain;
+	if (!confirm('Release ' + domain + '? Any device can claim it afterwards.')) {
+		return;
+	}
+
+	try {
+		await api('/admin/records/' + encodeURIComponent(domain), { method: 'DELETE' });
+		$('record-detail').hidden = true;
+		loadRecords();
+	} catch (err) {
+		showError(err);
+	}
+});
+
+// Audit log
+
+async function loadAudit() {
+	try {
+		const result = await api('/admin/audit-log?' + new URLSearchParams({ page: state.auditPage, per_page: PER_PAGE }));
+		const rows = result.entries.map((entry) => el('tr', {},
+			el('td', {}, formatTime(entry.created_at)),
+			el('td', {}, entry.actor),
+			el('td', {}, el('code', {}, entry.method + ' ' + entry.path)),
+			el('td', {}, entry.status),
+			el('td', {}, entry.source_ip),
+			el('td', {}, el('code', {}, entry.details ? JSON.stringify(entry.details) : ''))));
+
+		$('audit-body').replaceChildren(...rows);
+		renderPager('audit', result);
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+$('audit-prev').addEventListener('click', () => {
+	state.auditPage--;
+	loadAudit();
+});
+
+$('audit-next').addEventListener('click', () => {
+	state.auditPage++;
+	loadAudit();
+});
+
+// Configuration
+
+async function loadConfig() {
+	try {
+		const config = await api('/admin/config');
+		$('config-body').textContent = JSON.stringify(config, null, 2);
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+signIn();
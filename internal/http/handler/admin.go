@@ -0,0 +1,474 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/dns"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/internal/identity"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/gofiber/fiber/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxBulkNames = 1000
+
+var errRecordNotFound = errors.New("Record not found")
+
+// ReloadConfig reads the config file again and applies what can change live,
+// like sending SIGHUP does.
+func ReloadConfig(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		restart, err := rc.Reload()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to reload config: " + err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":          "Config reloaded successfully",
+			"restart_required": restart,
+		})
+	}
+}
+
+// SetDevice sets the plan of a device and optionally a name quota of its own,
+// which replaces the one of the plan.
+func SetDevice(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
+		type RequestBody struct {
+			Plan     string `json:"plan"`
+			MaxNames *int   `json:"max_names"`
+		}
+
+		var body RequestBody
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+		}
+
+		uuid := c.Params("uuid")
+		if len(uuid) != 36 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing or invalid UUID"})
+		}
+
+		if _, ok := cfg.Plans[body.Plan]; body.Plan != "" && !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Errorf("Unknown plan %s", body.Plan).Error(),
+			})
+		}
+
+		if body.MaxNames != nil && *body.MaxNames < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "max_names needs to be at least 1"})
+		}
+
+		device := &model.Device{
+			UUID:     uuid,
+			Plan:     body.Plan,
+			MaxNames: body.MaxNames,
+		}
+
+		if _, err := db.Database.SetDevice(device); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		middleware.SetAuditDetails(c, device)
+
+		device, err := db.Database.GetDevice(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Device successfully updated",
+			"device":  device,
+		})
+	}
+}
+
+// GetIdentityStats returns the cache, latency and circuit breaker counters of
+// the remote identity verifier.
+func GetIdentityStats(guard *identity.Guard) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(guard.Stats())
+	}
+}
+
+// GetStats returns the query counters of the DNS server and how long it has
+// been running, for the dashboard to chart query rates from.
+func GetStats(server *dns.DNSServer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"start_time":     server.StartTime,
+			"uptime_seconds": time.Now().Unix() - server.StartTime,
+			"dns":            server.Statistics(),
+		})
+	}
+}
+
+// GetHealth checks whether the database can be reached and, when the remote
+// identity verifier is used, whether its circuit is closed. It answers 503
+// when the database fails, as nothing works without it.
+func GetHealth(rc *config.Reloadable, guard *identity.Guard) fiber.Handler {
+	type Check struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	return func(c *fiber.Ctx) error {
+		status := "ok"
+		code := fiber.StatusOK
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), 2*time.Second)
+		defer cancel()
+
+		database := Check{Name: "database", Status: "ok"}
+		if err := db.Database.Ping(ctx); err != nil {
+			database.Status = "failing"
+			database.Detail = err.Error()
+			status = "failing"
+			code = fiber.StatusServiceUnavailable
+		}
+
+		checks := []Check{database}
+
+		if slices.Contains(rc.Get().GetIdentityVerifiers(), "remote") {
+			stats := guard.Stats()
+
+			remote := Check{Name: "identity", Status: "ok", Detail: "circuit " + stats.CircuitState}
+			if stats.CircuitState != "closed" {
+				remote.Status = "degraded"
+				if status == "ok" {
+					status = "degraded"
+				}
+			}
+
+			checks = append(checks, remote)
+		}
+
+		return c.Status(code).JSON(fiber.Map{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
+
+// GetConfig returns the running config with tokens and secrets masked.
+func GetConfig(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(rc.Get().Redacted())
+	}
+}
+
+// GetAdminRecordHistory lists the changes of a name, whichever devices held
+// it, newest first.
+func GetAdminRecordHistory(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Limit needs to be between 1 and 1000"})
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(c.Params("domain"), "."))
+
+	history, err := db.Database.GetRecordHistory("", domain, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": history,
+	})
+}
+
+// SearchRecords returns a page of the records of all devices, optionally
+// narrowed down by q, uuid and zone.
+func SearchRecords(c *fiber.Ctx) error {
+	page, perPage, ok := pageParams(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Page needs to be at least 1 and per_page between 1 and 500"})
+	}
+
+	records, total, err := db.Database.SearchRecords(model.RecordFilter{
+		Query:  c.Query("q"),
+		UUID:   c.Query("uuid"),
+		Zone:   strings.ToLower(strings.TrimSuffix(c.Query("zone"), ".")),
+		Offset: (page - 1) * perPage,
+		Limit:  perPage,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"records":  records,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// GetDevice returns a device with its names, active tokens, delegations and
+// name quota.
+func GetDevice(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uuid := c.Params("uuid")
+
+		device, err := db.Database.GetDevice(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if device == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Device not found"})
+		}
+
+		records, err := db.Database.GetRecords(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		tokens, err := db.Database.GetDeviceTokens(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		delegations, err := db.Database.GetDelegations(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		signingKey, err := db.Database.GetSigningKey(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		quota, err := nameQuota(rc.Get(), uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"device":          device,
+			"records":         records,
+			"tokens":          tokens,
+			"delegations":     delegations,
+			"has_signing_key": signingKey != "",
+			"quota":           quota,
+		})
+	}
+}
+
+// ReleaseRecord deletes a name whichever device holds it, so anyone can claim
+// it again.
+func ReleaseRecord(c *fiber.Ctx) error {
+	domain := strings.ToLower(strings.TrimSuffix(c.Params("domain"), "."))
+
+	holder, err := releaseName(domain, adminChange(c, "release"))
+	middleware.SetAuditDetails(c, fiber.Map{"domain": domain, "previous_uuid": holder})
+
+	if errors.Is(err, errRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Record successfully released",
+	})
+}
+
+// ReassignRecord moves a name to the device given as uuid, regardless of
+// its name quota.
+func ReassignRecord(c *fiber.Ctx) error {
+	type RequestBody struct {
+		UUID string `json:"uuid"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+	}
+
+	if len(body.UUID) != 36 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing or invalid UUID"})
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(c.Params("domain"), "."))
+
+	holder, err := reassignName(domain, body.UUID, adminChange(c, "reassign"))
+	middleware.SetAuditDetails(c, fiber.Map{"domain": domain, "previous_uuid": holder, "uuid": body.UUID})
+
+	if errors.Is(err, errRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Record successfully reassigned",
+	})
+}
+
+// BulkRecords releases or reassigns many names at once. Each name is changed
+// on its own, so the result tells which ones failed.
+func BulkRecords(c *fiber.Ctx) error {
+	type RequestBody struct {
+		Action  string   `json:"action"`
+		Domains []string `json:"domains"`
+		UUID    string   `json:"uuid"`
+	}
+
+	type Result struct {
+		Domain       string `json:"domain"`
+		PreviousUUID string `json:"previous_uuid,omitempty"`
+		Error        string `json:"error,omitempty"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+	}
+
+	switch body.Action {
+	case "release":
+	case "reassign":
+		if len(body.UUID) != 36 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing or invalid UUID"})
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Action needs to be release or reassign"})
+	}
+
+	if len(body.Domains) == 0 || len(body.Domains) > maxBulkNames {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Errorf("Domains need to list between 1 and %d names", maxBulkNames).Error(),
+		})
+	}
+
+	change := adminChange(c, body.Action)
+	results := make([]Result, 0, len(body.Domains))
+	failed := 0
+
+	for _, domain := range body.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+		var holder string
+		var err error
+		if body.Action == "release" {
+			holder, err = releaseName(domain, change)
+		} else {
+			holder, err = reassignName(domain, body.UUID, change)
+		}
+
+		result := Result{Domain: domain, PreviousUUID: holder}
+		if err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	middleware.SetAuditDetails(c, fiber.Map{"action": body.Action, "uuid": body.UUID, "results": results})
+
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Bulk %s finished, %d of %d names failed", body.Action, failed, len(results)),
+		"results": results,
+	})
+}
+
+// GetAuditLog returns a page of the audit log of the admin API, newest first.
+func GetAuditLog(c *fiber.Ctx) error {
+	page, perPage, ok := pageParams(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Page needs to be at least 1 and per_page between 1 and 500"})
+	}
+
+	entries, total, err := db.Database.GetAuditLog((page-1)*perPage, perPage)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"entries":  entries,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// releaseName deletes a name from the device holding it and returns that
+// device.
+func releaseName(domain string, change model.Change) (string, error) {
+	record, err := db.Database.GetRecordByDomain(domain)
+	if err != nil {
+		return "", err
+	}
+
+	if record == nil {
+		return "", errRecordNotFound
+	}
+
+	success, err := db.Database.DeleteRecord(record.UUID, domain, change)
+	if err != nil {
+		return record.UUID, err
+	}
+
+	if !success {
+		return record.UUID, errRecordNotFound
+	}
+
+	return record.UUID, nil
+}
+
+// reassignName moves a name to another device and returns the device that
+// held it.
+func reassignName(domain string, uuid string, change model.Change) (string, error) {
+	old, err := db.Database.GetRecordByDomain(domain)
+	if err != nil {
+		return "", err
+	}
+
+	if old == nil {
+		return "", errRecordNotFound
+	}
+
+	record, err := db.Database.ReassignRecord(domain, uuid, change)
+	if err != nil {
+		return old.UUID, err
+	}
+
+	if record == nil {
+		return old.UUID, errRecordNotFound
+	}
+
+	return old.UUID, nil
+}
+
+// adminChange journals changes made through the admin API by the admin
+// making them.
+func adminChange(c *fiber.Ctx, action string) model.Change {
+	return model.Change{
+		Actor:    "admin:" + middleware.AdminActor(c),
+		SourceIP: c.IP(),
+		Action:   action,
+	}
+}
+
+func pageParams(c *fiber.Ctx) (int, int, bool) {
+	page := c.QueryInt("page", 1)
+	perPage := c.QueryInt("per_page", 50)
+
+	return page, perPage, page >= 1 && perPage >= 1 && perPage <= 500
+}
@@ -3,14 +3,16 @@ package handler
 import (
 	"fmt"
 	"github.com/DifuseHQ/dddns/internal/db"
-	"github.com/DifuseHQ/dddns/internal/utils"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
 	"github.com/DifuseHQ/dddns/pkg/config"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
 
-func IsDomainAvailable(cfg config.Config) fiber.Handler {
+func IsDomainAvailable(rc *config.Reloadable) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
 		domain := c.Params("domain")
 
 		if domain == "" {
@@ -28,17 +30,13 @@ func IsDomainAvailable(cfg config.Config) fiber.Handler {
 			})
 		}
 
-		if !utils.DomainEndsWith(domain, cfg.Domain) {
+		if _, err := requestZone(cfg, c, domain); err != nil {
 			return c.JSON(fiber.Map{
-				"error": fmt.Errorf("Invalid domain, needs to be a subdomain of %s", cfg.Domain).Error(),
+				"error": err.Error(),
 			})
 		}
 
-		var count int
-
-		query := `SELECT COUNT(*) FROM records WHERE domain = ?`
-
-		err = db.Database.QueryRow(query, domain).Scan(&count)
+		taken, err := db.Database.IsDomainTaken(domain, "")
 
 		if err != nil {
 			return c.JSON(fiber.Map{
@@ -47,15 +45,17 @@ func IsDomainAvailable(cfg config.Config) fiber.Handler {
 		}
 
 		return c.JSON(fiber.Map{
-			"available": count == 0,
+			"available": !taken,
 		})
 	}
 }
 
-func IsDomainTakenByElse(cfg config.Config) fiber.Handler {
+func IsDomainTakenByElse(rc *config.Reloadable) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
 		domain := c.Params("domain")
-		uuid := c.Query("uuid")
+		uuid := middleware.DeviceUUID(c)
 
 		if domain == "" {
 			return c.JSON(fiber.Map{
@@ -72,17 +72,13 @@ func IsDomainTakenByElse(cfg config.Config) fiber.Handler {
 			})
 		}
 
-		if !utils.DomainEndsWith(domain, cfg.Domain) {
+		if _, err := requestZone(cfg, c, domain); err != nil {
 			return c.JSON(fiber.Map{
-				"error": fmt.Errorf("Invalid domain, needs to be a subdomain of %s", cfg.Domain).Error(),
+				"error": err.Error(),
 			})
 		}
 
-		var count int
-
-		query := `SELECT COUNT(*) FROM records WHERE domain = ? AND uuid != ?`
-
-		err = db.Database.QueryRow(query, domain, uuid).Scan(&count)
+		taken, err := db.Database.IsDomainTaken(domain, uuid)
 
 		if err != nil {
 			return c.JSON(fiber.Map{
@@ -91,7 +87,7 @@ func IsDomainTakenByElse(cfg config.Config) fiber.Handler {
 		}
 
 		return c.JSON(fiber.Map{
-			"available": count == 0,
+			"available": !taken,
 		})
 	}
 }
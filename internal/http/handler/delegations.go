@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+func GetDelegations(c *fiber.Ctx) error {
+	delegations, err := db.Database.GetDelegations(middleware.DeviceUUID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"delegations": delegations,
+	})
+}
+
+// SetDelegation delegates a name below the device's own name to the given
+// nameservers. Nameservers inside the delegated name need glue addresses.
+func SetDelegation(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
+		uuid := middleware.DeviceUUID(c)
+
+		type NameServerBody struct {
+			Name string `json:"name" validate:"required,fqdn"`
+			IPv4 string `json:"ipv4" validate:"omitempty,ipv4"`
+			IPv6 string `json:"ipv6" validate:"omitempty,ipv6"`
+		}
+
+		type RequestBody struct {
+			Domain      string           `json:"domain" validate:"required,fqdn"`
+			NameServers []NameServerBody `json:"nameservers" validate:"required,min=1,max=8,dive"`
+		}
+
+		var body RequestBody
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		delegation := &model.Delegation{
+			Domain: strings.ToLower(strings.TrimSuffix(body.Domain, ".")),
+			UUID:   uuid,
+		}
+
+		if _, err := requestZone(cfg, c, delegation.Domain); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		for _, ns := range body.NameServers {
+			server := model.DelegationServer{
+				Name:       strings.ToLower(strings.TrimSuffix(ns.Name, ".")),
+				ARecord:    ns.IPv4,
+				AAAARecord: ns.IPv6,
+			}
+
+			inside := server.Name == delegation.Domain || strings.HasSuffix(server.Name, "."+delegation.Domain)
+			if inside && server.ARecord == "" && server.AAAARecord == "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Errorf("Nameserver %s is inside %s and needs glue addresses", server.Name, delegation.Domain).Error(),
+				})
+			}
+
+			delegation.NameServers = append(delegation.NameServers, server)
+		}
+
+		_, err := db.Database.SetDelegation(delegation)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":    "Delegation successfully created or updated",
+			"delegation": delegation,
+		})
+	}
+}
+
+func DeleteDelegation(c *fiber.Ctx) error {
+	domain := strings.ToLower(strings.TrimSuffix(c.Query("domain"), "."))
+
+	success, err := db.Database.DeleteDelegation(domain, middleware.DeviceUUID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete delegation"})
+	}
+
+	if !success {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Delegation not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Delegation successfully deleted",
+	})
+}
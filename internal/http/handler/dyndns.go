@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"strings"
+)
+
+// maxDynDNSHosts is how many hostnames one dyndns2 update may name.
+const maxDynDNSHosts = 20
+
+// DynDNSUpdate implements the dyndns2 update protocol used by routers and
+// ddclient. Clients authenticate with HTTP Basic auth, the device UUID as user
+// name and one of its tokens with the update-address scope as password. Each
+// hostname gets a line in the answer, like "good 192.0.2.1" or "nohost".
+func DynDNSUpdate(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+
+		uuid, ok := dyndnsDevice(c)
+		if !ok {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="dddns"`)
+			return c.Status(fiber.StatusUnauthorized).SendString("badauth")
+		}
+
+		hostnames := strings.Split(c.Query("hostname"), ",")
+		if len(hostnames) > maxDynDNSHosts {
+			return c.SendString("numhost")
+		}
+
+		ipv4, ipv6, ok := dyndnsAddresses(c)
+		if !ok {
+			return c.SendString("dnserr")
+		}
+
+		results := make([]string, 0, len(hostnames))
+		for _, hostname := range hostnames {
+			results = append(results, dyndnsUpdateHost(cfg, c, uuid, hostname, ipv4, ipv6))
+		}
+
+		return c.SendString(strings.Join(results, "\n"))
+	}
+}
+
+// dyndnsUpdateHost points one hostname at the addresses given, keeping the
+// address of the family that wasn't, and returns its result line. The update
+// is checked and stored like any other, by storeRecord.
+func dyndnsUpdateHost(cfg *config.Config, c *fiber.Ctx, uuid string, hostname string, ipv4 string, ipv6 string) string {
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+
+	if err := validator.New().Var(domain, "required,fqdn"); err != nil {
+		return "notfqdn"
+	}
+
+	existing, err := db.Database.GetRecordByDomain(domain)
+	if err != nil {
+		return "911"
+	}
+
+	record := &model.Record{
+		UUID:       uuid,
+		Domain:     domain,
+		ARecord:    ipv4,
+		AAAARecord: ipv6,
+	}
+
+	addresses := strings.Trim(ipv4+","+ipv6, ",")
+
+	if existing != nil && existing.UUID == uuid {
+		record.Alias = existing.Alias
+
+		if ipv4 == "" {
+			record.ARecord = existing.ARecord
+		}
+
+		if ipv6 == "" {
+			record.AAAARecord = existing.AAAARecord
+		}
+
+		if existing.ARecord == record.ARecord && existing.AAAARecord == record.AAAARecord {
+			return "nochg " + addresses
+		}
+	}
+
+	success, status, err := storeRecord(cfg, c, record, model.Change{
+		Actor:    uuid,
+		SourceIP: c.IP(),
+	})
+
+	switch {
+	case errors.Is(err, db.ErrQuotaExceeded):
+		return "numhost"
+	case status == fiber.StatusBadRequest || status == fiber.StatusForbidden || status == fiber.StatusConflict:
+		return "nohost"
+	case err != nil || !success:
+		logger.Log.Debug("Failed to update ", domain, " through dyndns2 ", err)
+		return "911"
+	}
+
+	return "good " + addresses
+}
+
+// dyndnsDevice returns the device of the Basic credentials, which need to be
+// the device UUID and an active token of it with the update-address scope.
+func dyndnsDevice(c *fiber.Ctx) (string, bool) {
+	uuid, secret, ok := basicAuth(c)
+	if !ok || secret == "" {
+		return "", false
+	}
+
+	token, err := db.Database.GetDeviceTokenByHash(auth.HashToken(secret))
+	if err != nil || token == nil || !strings.EqualFold(token.UUID, uuid) {
+		return "", false
+	}
+
+	if !auth.HasScope(token.Scopes, auth.ScopeUpdateAddress) {
+		return "", false
+	}
+
+	return token.UUID, true
+}
+
+// dyndnsAddresses returns the addresses of the myip parameter, which may list
+// an IPv4 and an IPv6 address separated by a comma, and of myipv6. Without
+// either, the address the request came from is used.
+func dyndnsAddresses(c *fiber.Ctx) (string, string, bool) {
+	var ipv4, ipv6 string
+
+	given := strings.Split(c.Query("myip"), ",")
+	if v6 := c.Query("myipv6"); v6 != "" {
+		given = append(given, v6)
+	}
+
+	for _, address := range given {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			return "", "", false
+		case ip.To4() != nil:
+			ipv4 = ip.String()
+		default:
+			ipv6 = ip.String()
+		}
+	}
+
+	if ipv4 == "" && ipv6 == "" {
+		ip := net.ParseIP(c.IP())
+		if ip == nil {
+			return "", "", false
+		}
+
+		if ip.To4() != nil {
+			ipv4 = ip.To4().String()
+		} else {
+			ipv6 = ip.String()
+		}
+	}
+
+	return ipv4, ipv6, true
+}
+
+// basicAuth returns the user name and password of a Basic Authorization
+// header.
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Basic ")
+	if !ok {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
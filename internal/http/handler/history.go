@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// GetRecordHistory lists the changes of the device's records, or of the name
+// given as domain, newest first.
+func GetRecordHistory(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Limit needs to be between 1 and 1000"})
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(c.Query("domain"), "."))
+
+	history, err := db.Database.GetRecordHistory(middleware.DeviceUUID(c), domain, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": history,
+	})
+}
+
+// RollbackRecord restores a record of the device to the values it had after
+// the given change of its history. A released name is added again.
+func RollbackRecord(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
+		uuid := middleware.DeviceUUID(c)
+
+		type RequestBody struct {
+			ID int64 `json:"id"`
+		}
+
+		var body RequestBody
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+		}
+
+		entry, err := db.Database.GetRecordHistoryEntry(body.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if entry == nil || entry.UUID != uuid {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "History entry not found"})
+		}
+
+		if entry.NewValue == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Errorf("History entry %d deleted the record, there is nothing to restore", entry.ID).Error(),
+			})
+		}
+
+		value := entry.NewValue
+
+		record := &model.Record{
+			UUID:       uuid,
+			Domain:     value.Domain,
+			ARecord:    value.ARecord,
+			AAAARecord: value.AAAARecord,
+			Alias:      value.Alias,
+		}
+
+		// Entries journaled before view overrides were leave them as they
+		// are. Overrides of views no longer configured aren't restored.
+		if value.Views != nil {
+			record.Views = []model.RecordView{}
+			for _, view := range value.Views {
+				if viewExists(cfg, view.View) {
+					record.Views = append(record.Views, model.RecordView{
+						View:       view.View,
+						ARecord:    view.ARecord,
+						AAAARecord: view.AAAARecord,
+					})
+				}
+			}
+		}
+
+		_, status, err := storeRecord(cfg, c, record, model.Change{
+			Actor:    uuid,
+			SourceIP: c.IP(),
+			Action:   "rollback",
+		})
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Record successfully rolled back",
+			"record":  record,
+		})
+	}
+}
@@ -14,7 +14,7 @@ type DNSStatsPageData struct {
 
 func GetDNSStatistics(dns *dns.DNSServer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		stats := dns.Stats
+		stats := dns.Statistics()
 		startTime := time.Unix(dns.StartTime, 0).UTC().Format("2006-01-02 15:04:05 UTC")
 
 		pageData := DNSStatsPageData{
@@ -73,6 +73,10 @@ func GetDNSStatistics(dns *dns.DNSServer) fiber.Handler {
 						<td>AAAA Record Queries</td>
 						<td>{{.Stats.AAAAQueries}}</td>
 					</tr>
+					<tr>
+						<td>TXT Record Queries</td>
+						<td>{{.Stats.TXTQueries}}</td>
+					</tr>
 					<tr>
 						<td>SOA Record Queries</td>
 						<td>{{.Stats.SOAQueries}}</td>
@@ -81,6 +85,18 @@ func GetDNSStatistics(dns *dns.DNSServer) fiber.Handler {
 						<td>NS Record Queries</td>
 						<td>{{.Stats.NSQueries}}</td>
 					</tr>
+					<tr>
+						<td>Referrals</td>
+						<td>{{.Stats.Referrals}}</td>
+					</tr>
+					<tr>
+						<td>Rate Limited Queries</td>
+						<td>{{.Stats.RateLimited}}</td>
+					</tr>
+					<tr>
+						<td>Bad Cookie Responses</td>
+						<td>{{.Stats.BadCookies}}</td>
+					</tr>
 				</table>
 			</body>
 			</html>
@@ -1,21 +1,37 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"github.com/DifuseHQ/dddns/internal/db"
 	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/dns"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
 	"github.com/DifuseHQ/dddns/pkg/config"
 	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
 )
 
-func CreateRecord(cfg config.Config) fiber.Handler {
+func CreateRecord(rc *config.Reloadable) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		uuid := c.Query("uuid")
+		cfg := rc.Get()
+
+		uuid := middleware.DeviceUUID(c)
+
+		type ViewBody struct {
+			IPv4 string `json:"ipv4" validate:"omitempty,ipv4"`
+			IPv6 string `json:"ipv6" validate:"omitempty,ipv6"`
+		}
 
 		type RequestBody struct {
-			Domain string `json:"domain"`
-			IPv4   string `json:"ipv4"`
-			IPv6   string `json:"ipv6"`
+			Domain string              `json:"domain"`
+			IPv4   string              `json:"ipv4" validate:"omitempty,ipv4"`
+			IPv6   string              `json:"ipv6" validate:"omitempty,ipv6"`
+			Alias  string              `json:"alias"`
+			Views  map[string]ViewBody `json:"views" validate:"dive"`
 		}
 
 		var body RequestBody
@@ -23,19 +39,48 @@ func CreateRecord(cfg config.Config) fiber.Handler {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
 		}
 
+		if err := validator.New().Struct(body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		body.Domain = strings.ToLower(body.Domain)
+		body.Alias = strings.ToLower(strings.TrimSuffix(body.Alias, "."))
+
+		var overrides []model.RecordView
+		if body.Views != nil {
+			overrides = []model.RecordView{}
+		}
+
+		for name, view := range body.Views {
+			if !viewExists(cfg, name) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Errorf("Unknown view %s", name).Error(),
+				})
+			}
+
+			overrides = append(overrides, model.RecordView{
+				Domain:     body.Domain,
+				View:       name,
+				ARecord:    view.IPv4,
+				AAAARecord: view.IPv6,
+			})
+		}
+
 		record := &model.Record{
 			UUID:       uuid,
 			Domain:     body.Domain,
 			ARecord:    body.IPv4,
 			AAAARecord: body.IPv6,
+			Alias:      body.Alias,
+			Views:      overrides,
 		}
 
-		success, err := db.InsertOrUpdateRecord(db.Database, record, cfg.Domain)
+		success, status, err := storeRecord(cfg, c, record, model.Change{
+			Actor:    uuid,
+			SourceIP: c.IP(),
+		})
 		if err != nil {
-			logger.Log.Debug("Failed to insert or update record ", err.Error())
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
 		}
 
 		if success {
@@ -49,19 +94,155 @@ func CreateRecord(cfg config.Config) fiber.Handler {
 	}
 }
 
-func DeleteRecord(c *fiber.Ctx) error {
-	uuid := c.Query("uuid")
+// quotaError is the error of storeRecord when a device already holds as many
+// names as it can, telling it apart from other refusals.
+type quotaError int
+
+func (e quotaError) Error() string {
+	return fmt.Sprintf("Device can't hold more than %d names", int(e))
+}
+
+func (e quotaError) Unwrap() error {
+	return db.ErrQuotaExceeded
+}
+
+// storeRecord checks a device may point a name at what record says and stores
+// it. It returns the status and error to answer the request with when the
+// record can't be stored. Rollbacks go through it as well, so restoring a
+// name is checked like claiming it.
+func storeRecord(cfg *config.Config, c *fiber.Ctx, record *model.Record, change model.Change) (bool, int, error) {
+	if record.Alias != "" {
+		if err := validator.New().Var(record.Alias, "fqdn"); err != nil {
+			return false, fiber.StatusBadRequest, fmt.Errorf("Invalid alias target")
+		}
 
-	success, err := db.DeleteRecord(db.Database, uuid)
+		if cfg.FindZone(record.Alias) != nil {
+			return false, fiber.StatusBadRequest, fmt.Errorf("Alias target can't be inside a zone served here")
+		}
+	}
+
+	zone, err := requestZone(cfg, c, record.Domain)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete record"})
+		return false, fiber.StatusBadRequest, err
+	}
+
+	if zone.IsReserved(record.Domain) {
+		return false, fiber.StatusBadRequest, fmt.Errorf("Domain %s is reserved", record.Domain)
+	}
+
+	if zone.RegistrationClosed {
+		existing, err := db.Database.GetRecordByDomain(record.Domain)
+		if err != nil {
+			return false, fiber.StatusInternalServerError, err
+		}
+
+		if existing == nil || existing.UUID != record.UUID {
+			return false, fiber.StatusForbidden, fmt.Errorf("Registration of new names in %s is closed", zone.Domain)
+		}
+	}
+
+	quota, err := nameQuota(cfg, record.UUID)
+	if err != nil {
+		return false, fiber.StatusInternalServerError, err
+	}
+
+	success, err := db.Database.InsertOrUpdateRecord(record, zone.Domain, quota, change)
+	if errors.Is(err, db.ErrDomainTaken) {
+		return false, fiber.StatusConflict, fmt.Errorf("Domain %s is taken by someone else", record.Domain)
+	}
+
+	if errors.Is(err, db.ErrQuotaExceeded) {
+		return false, fiber.StatusForbidden, quotaError(quota)
 	}
 
-	if success {
+	if err != nil {
+		logger.Log.Debug("Failed to insert or update record ", err.Error())
+		return false, fiber.StatusInternalServerError, err
+	}
+
+	return success, fiber.StatusOK, nil
+}
+
+// GetRecords lists the names held by the device, how many it can hold and how
+// many queries this node answered for each name since it started. The counts
+// aren't shared between nodes, which query_counts_scope tells API users.
+func GetRecords(rc *config.Reloadable, server *dns.DNSServer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uuid := middleware.DeviceUUID(c)
+
+		records, err := db.Database.GetRecords(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		quota, err := nameQuota(rc.Get(), uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		queryCounts := make(map[string]int64, len(records))
+		for _, record := range records {
+			queryCounts[record.Domain] = server.QueryCount(uuid, record.Domain)
+		}
+
 		return c.JSON(fiber.Map{
-			"message": "Record successfully deleted",
+			"records":            records,
+			"quota":              quota,
+			"query_counts":       queryCounts,
+			"query_counts_scope": "this node, since start",
+			"query_counts_since": time.Unix(server.StartTime, 0).UTC(),
 		})
-	} else {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Record deletion failed"})
 	}
 }
+
+// DeleteRecord deletes the name given as domain, or all names of the device
+// when no domain is given.
+func DeleteRecord(c *fiber.Ctx) error {
+	uuid := middleware.DeviceUUID(c)
+	domain := strings.ToLower(strings.TrimSuffix(c.Query("domain"), "."))
+
+	success, err := db.Database.DeleteRecord(uuid, domain, model.Change{
+		Actor:    uuid,
+		SourceIP: c.IP(),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete record"})
+	}
+
+	if !success && domain != "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Record not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Record successfully deleted",
+	})
+}
+
+// nameQuota returns how many names the device of uuid can hold, set for the
+// device itself or by its plan.
+func nameQuota(cfg *config.Config, uuid string) (int, error) {
+	device, err := db.Database.GetDevice(uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	if device == nil {
+		return cfg.NameQuota(""), nil
+	}
+
+	if device.MaxNames != nil {
+		return *device.MaxNames, nil
+	}
+
+	return cfg.NameQuota(device.Plan), nil
+}
+
+func viewExists(cfg *config.Config, name string) bool {
+	for _, view := range cfg.Views {
+		if view.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetSigningKey generates a new key the device signs requests with, replacing
+// any previous one. The key is only shown in this response.
+func SetSigningKey(c *fiber.Ctx) error {
+	key, err := auth.GenerateSigningKey()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate signing key"})
+	}
+
+	if _, err := db.Database.SetSigningKey(middleware.DeviceUUID(c), key); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Signing key successfully set",
+		"key":     key,
+	})
+}
+
+// DeleteSigningKey removes the signing key of the device, so it can't sign
+// requests anymore.
+func DeleteSigningKey(c *fiber.Ctx) error {
+	if _, err := db.Database.SetSigningKey(middleware.DeviceUUID(c), ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Signing key successfully deleted",
+	})
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterDevice issues the first token of a device whose UUID was verified.
+// Devices holding tokens get further ones through IssueToken instead, so
+// knowing a UUID isn't enough to obtain a token once the device has one.
+func RegisterDevice(c *fiber.Ctx) error {
+	return issueToken(c, db.Database.RegisterDevice, middleware.DeviceUUID(c), []string{auth.ScopeUpdateAddress, auth.ScopeManageTXT}, "Device successfully registered")
+}
+
+// GetTokens lists the tokens of the device, without their secrets.
+func GetTokens(c *fiber.Ctx) error {
+	tokens, err := db.Database.GetDeviceTokens(middleware.DeviceUUID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"tokens": tokens,
+	})
+}
+
+// IssueToken issues another token of the device, with at most the scopes of
+// the token asking for it. Requests authenticated by their UUID alone can't
+// issue tokens.
+func IssueToken(c *fiber.Ctx) error {
+	type RequestBody struct {
+		Scopes []string `json:"scopes"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+	}
+
+	scopes, err := auth.ParseScopes(body.Scopes)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	current := middleware.DeviceToken(c)
+	if current == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing bearer token"})
+	}
+
+	if scope := auth.MissingScope(current.Scopes, scopes); scope != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": fmt.Errorf("Token can't grant the %s scope it lacks", scope).Error(),
+		})
+	}
+
+	return issueToken(c, db.Database.CreateDeviceToken, middleware.DeviceUUID(c), scopes, "Token successfully issued")
+}
+
+// RotateToken replaces a token of the device by a new one with the same
+// scopes. Tokens can always rotate themselves.
+func RotateToken(c *fiber.Ctx) error {
+	id, status, err := tokenParam(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	secret, prefix, hash, err := auth.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
+	}
+
+	token, err := db.Database.RotateDeviceToken(middleware.DeviceUUID(c), id, prefix, hash)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if token == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Token not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Token successfully rotated",
+		"token":   secret,
+		"details": token,
+	})
+}
+
+// RevokeToken revokes a token of the device. Tokens can always revoke
+// themselves.
+func RevokeToken(c *fiber.Ctx) error {
+	id, status, err := tokenParam(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	success, err := db.Database.RevokeDeviceToken(middleware.DeviceUUID(c), id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !success {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Token not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Token successfully revoked",
+	})
+}
+
+// tokenParam returns the token ID of the path once the request may change
+// that token, or the status and error to answer with. A token may change
+// itself, and other tokens of the device when it has the update-address scope
+// and every scope of the token it changes, since rotating hands out a token
+// with those. Requests authenticated by their UUID alone can't change tokens.
+func tokenParam(c *fiber.Ctx) (int64, int, error) {
+	id, _ := c.ParamsInt("id")
+
+	current := middleware.DeviceToken(c)
+	if current == nil {
+		return 0, fiber.StatusUnauthorized, fmt.Errorf("Missing bearer token")
+	}
+
+	if current.ID == int64(id) {
+		return current.ID, fiber.StatusOK, nil
+	}
+
+	target, err := db.Database.GetDeviceToken(current.UUID, int64(id))
+	if err != nil {
+		return 0, fiber.StatusInternalServerError, err
+	}
+
+	if target == nil {
+		return 0, fiber.StatusNotFound, fmt.Errorf("Token not found")
+	}
+
+	if err := authorizeTokenChange(current, target); err != nil {
+		return 0, fiber.StatusForbidden, err
+	}
+
+	return target.ID, fiber.StatusOK, nil
+}
+
+// authorizeTokenChange reports why current may not rotate or revoke target,
+// another token of the same device.
+func authorizeTokenChange(current *model.DeviceToken, target *model.DeviceToken) error {
+	if !auth.HasScope(current.Scopes, auth.ScopeUpdateAddress) {
+		return fmt.Errorf("Token lacks the %s scope", auth.ScopeUpdateAddress)
+	}
+
+	if scope := auth.MissingScope(current.Scopes, target.Scopes); scope != "" {
+		return fmt.Errorf("Token can't change a token with the %s scope it lacks", scope)
+	}
+
+	return nil
+}
+
+// issueToken generates a token with scopes, stores it with create and answers
+// with its secret, shown only this once.
+func issueToken(c *fiber.Ctx, create func(*model.DeviceToken, string) (*model.DeviceToken, error), uuid string, scopes []string, message string) error {
+	secret, prefix, hash, err := auth.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
+	}
+
+	token, err := create(&model.DeviceToken{
+		UUID:   uuid,
+		Prefix: prefix,
+		Scopes: scopes,
+	}, hash)
+	if errors.Is(err, db.ErrDeviceRegistered) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Device is already registered, use one of its tokens"})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": message,
+		"token":   secret,
+		"details": token,
+	})
+}
@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dddns-handler")
+	if err != nil {
+		panic(err)
+	}
+
+	logger.InitConsoleLogger(0)
+	logger.Log.SetOutput(io.Discard)
+
+	db.InitDB("", filepath.Join(dir, "dddns.db"), true, []string{"example.com"})
+
+	code := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// acceptAll accepts every UUID, standing in for identity verification.
+type acceptAll struct{}
+
+func (acceptAll) Verify(ctx context.Context, uuid string) (bool, error) {
+	return true, nil
+}
+
+// tokenApp routes the token API like the server does.
+func tokenApp() *fiber.App {
+	app := fiber.New()
+
+	device := middleware.DeviceAuthMiddleware(acceptAll{}, func() bool { return true })
+	bearer := middleware.DeviceAuthMiddleware(acceptAll{}, func() bool { return false })
+
+	app.Post("/auth/register", middleware.UUIDCheckMiddleware(acceptAll{}), RegisterDevice)
+	app.Get("/auth/tokens", device(auth.ScopeReadOnly), GetTokens)
+	app.Post("/auth/tokens", bearer(auth.ScopeUpdateAddress), IssueToken)
+	app.Post("/auth/tokens/:id/rotate", bearer(auth.ScopeReadOnly), RotateToken)
+	app.Delete("/auth/tokens/:id", bearer(auth.ScopeReadOnly), RevokeToken)
+
+	return app
+}
+
+type testToken struct {
+	id     int64
+	secret string
+}
+
+// createToken stores a token with scopes for the device of uuid.
+func createToken(t *testing.T, uuid string, scopes ...string) testToken {
+	t.Helper()
+
+	secret, prefix, hash, err := auth.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := db.Database.CreateDeviceToken(&model.DeviceToken{
+		UUID:   uuid,
+		Prefix: prefix,
+		Scopes: scopes,
+	}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testToken{id: token.ID, secret: secret}
+}
+
+var lastTestUUID atomic.Int64
+
+// testUUID returns the UUID of a device no test used before.
+func testUUID() string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", lastTestUUID.Add(1))
+}
+
+func TestChangeTokenAuthorization(t *testing.T) {
+	full := []string{auth.ScopeUpdateAddress, auth.ScopeManageTXT}
+
+	tests := []struct {
+		name        string
+		callerScope []string
+		targetScope []string
+		// self changes the caller's own token, otherDevice a token of
+		// another device and legacy authenticates by the uuid parameter
+		self        bool
+		otherDevice bool
+		legacy      bool
+		want        int
+	}{
+		{name: "self read-only", callerScope: []string{auth.ScopeReadOnly}, self: true, want: fiber.StatusOK},
+		{name: "self full", callerScope: full, self: true, want: fiber.StatusOK},
+		{name: "same scopes", callerScope: full, targetScope: full, want: fiber.StatusOK},
+		{name: "narrower target", callerScope: full, targetScope: []string{auth.ScopeReadOnly}, want: fiber.StatusOK},
+		{name: "update-address over read-only", callerScope: []string{auth.ScopeUpdateAddress}, targetScope: []string{auth.ScopeReadOnly}, want: fiber.StatusOK},
+		{name: "target with more scopes", callerScope: []string{auth.ScopeUpdateAddress}, targetScope: full, want: fiber.StatusForbidden},
+		{name: "target with other scope", callerScope: []string{auth.ScopeUpdateAddress}, targetScope: []string{auth.ScopeManageTXT}, want: fiber.StatusForbidden},
+		{name: "caller without update-address", callerScope: []string{auth.ScopeManageTXT}, targetScope: []string{auth.ScopeManageTXT}, want: fiber.StatusForbidden},
+		{name: "read-only caller", callerScope: []string{auth.ScopeReadOnly}, targetScope: []string{auth.ScopeReadOnly}, want: fiber.StatusForbidden},
+		{name: "other device", callerScope: full, targetScope: []string{auth.ScopeReadOnly}, otherDevice: true, want: fiber.StatusNotFound},
+		{name: "legacy uuid auth", targetScope: full, legacy: true, want: fiber.StatusUnauthorized},
+	}
+
+	app := tokenApp()
+
+	for _, tt := range tests {
+		for _, action := range []string{"rotate", "revoke"} {
+			t.Run(tt.name+" "+action, func(t *testing.T) {
+				uuid := testUUID()
+
+				caller := createToken(t, uuid, tt.callerScope...)
+				target := caller
+				if !tt.self {
+					targetUUID := uuid
+					if tt.otherDevice {
+						targetUUID = testUUID()
+					}
+					target = createToken(t, targetUUID, tt.targetScope...)
+				}
+
+				path := fmt.Sprintf("/auth/tokens/%d", target.id)
+				method := fiber.MethodDelete
+				if action == "rotate" {
+					path += "/rotate"
+					method = fiber.MethodPost
+				}
+
+				req := httptest.NewRequest(method, path, nil)
+				if tt.legacy {
+					req = httptest.NewRequest(method, path+"?uuid="+uuid, nil)
+				} else {
+					req.Header.Set(fiber.HeaderAuthorization, "Bearer "+caller.secret)
+				}
+
+				res, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				body, _ := io.ReadAll(res.Body)
+				if res.StatusCode != tt.want {
+					t.Fatalf("status %d, want %d: %s", res.StatusCode, tt.want, body)
+				}
+
+				if tt.want == fiber.StatusOK {
+					if action == "rotate" && !strings.Contains(string(body), `"token":"dddns_`) {
+						t.Errorf("rotation didn't return the new token: %s", body)
+					}
+					return
+				}
+
+				// A refused change leaves the target usable.
+				if tt.self || tt.otherDevice {
+					return
+				}
+
+				token, err := db.Database.GetDeviceTokenByHash(auth.HashToken(target.secret))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if token == nil {
+					t.Error("refused change revoked the target token")
+				}
+			})
+		}
+	}
+}
+
+func TestIssueTokenAuthorization(t *testing.T) {
+	full := []string{auth.ScopeUpdateAddress, auth.ScopeManageTXT}
+
+	tests := []struct {
+		name        string
+		callerScope []string
+		scopes      string
+		legacy      bool
+		want        int
+	}{
+		{name: "subset", callerScope: full, scopes: `["read-only"]`, want: fiber.StatusOK},
+		{name: "same scopes", callerScope: full, scopes: `["update-address", "manage-txt"]`, want: fiber.StatusOK},
+		{name: "more scopes", callerScope: []string{auth.ScopeUpdateAddress}, scopes: `["manage-txt"]`, want: fiber.StatusForbidden},
+		{name: "without update-address", callerScope: []string{auth.ScopeManageTXT}, scopes: `["manage-txt"]`, want: fiber.StatusForbidden},
+		{name: "unknown scope", callerScope: full, scopes: `["admin"]`, want: fiber.StatusBadRequest},
+		{name: "legacy uuid auth", scopes: `["update-address", "manage-txt"]`, legacy: true, want: fiber.StatusUnauthorized},
+	}
+
+	app := tokenApp()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uuid := testUUID()
+			caller := createToken(t, uuid, tt.callerScope...)
+
+			body := strings.NewReader(`{"scopes": ` + tt.scopes + `}`)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/auth/tokens", body)
+			if tt.legacy {
+				req = httptest.NewRequest(fiber.MethodPost, "/auth/tokens?uuid="+uuid, body)
+			} else {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+caller.secret)
+			}
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.want {
+				b, _ := io.ReadAll(res.Body)
+				t.Fatalf("status %d, want %d: %s", res.StatusCode, tt.want, b)
+			}
+		})
+	}
+}
+
+func TestLegacyUUIDAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens int
+		// revoked revokes the tokens of the device again
+		revoked bool
+		want    int
+	}{
+		{name: "device without tokens", want: fiber.StatusOK},
+		{name: "device with a token", tokens: 1, want: fiber.StatusUnauthorized},
+		{name: "device with tokens", tokens: 2, want: fiber.StatusUnauthorized},
+		{name: "device with revoked tokens", tokens: 1, revoked: true, want: fiber.StatusOK},
+	}
+
+	app := tokenApp()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uuid := testUUID()
+
+			for n := 0; n < tt.tokens; n++ {
+				token := createToken(t, uuid, auth.ScopeReadOnly)
+				if tt.revoked {
+					if _, err := db.Database.RevokeDeviceToken(uuid, token.id); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/auth/tokens?uuid="+uuid, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.want {
+				b, _ := io.ReadAll(res.Body)
+				t.Fatalf("status %d, want %d: %s", res.StatusCode, tt.want, b)
+			}
+		})
+	}
+}
+
+func TestRegisterDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  int
+		revoked bool
+		// parallel registrations, of which exactly one may succeed
+		parallel int
+		want     int
+	}{
+		{name: "new device", parallel: 1, want: 1},
+		{name: "registered device", tokens: 1, parallel: 1, want: 0},
+		{name: "device with revoked tokens", tokens: 1, revoked: true, parallel: 1, want: 1},
+		{name: "concurrent registrations", parallel: 10, want: 1},
+	}
+
+	app := tokenApp()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uuid := testUUID()
+
+			for n := 0; n < tt.tokens; n++ {
+				token := createToken(t, uuid, auth.ScopeReadOnly)
+				if tt.revoked {
+					if _, err := db.Database.RevokeDeviceToken(uuid, token.id); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			statuses := make([]int, tt.parallel)
+
+			var wg sync.WaitGroup
+			for n := range statuses {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+
+					res, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/auth/register?uuid="+uuid, nil), -1)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					statuses[n] = res.StatusCode
+				}(n)
+			}
+			wg.Wait()
+
+			registered := 0
+			for _, status := range statuses {
+				switch status {
+				case fiber.StatusOK:
+					registered++
+				case fiber.StatusConflict:
+				default:
+					t.Errorf("registration answered with status %d", status)
+				}
+			}
+
+			if registered != tt.want {
+				t.Errorf("%d registrations succeeded, want %d", registered, tt.want)
+			}
+
+			tokens, err := db.Database.GetDeviceTokens(uuid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Either the registration or the token held before.
+			if len(tokens) != 1 {
+				t.Errorf("device holds %d tokens, want 1", len(tokens))
+			}
+		})
+	}
+}
+
+func TestRegisterDeviceConcurrently(t *testing.T) {
+	const parallel = 20
+
+	uuid := testUUID()
+	start := make(chan struct{})
+	errs := make([]error, parallel)
+
+	var wg sync.WaitGroup
+	for n := range errs {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+
+			_, _, hash, err := auth.GenerateToken()
+			if err != nil {
+				errs[n] = err
+				return
+			}
+
+			<-start
+			_, errs[n] = db.Database.RegisterDevice(&model.DeviceToken{
+				UUID:   uuid,
+				Prefix: "dddns_test",
+				Scopes: []string{auth.ScopeReadOnly},
+			}, hash)
+		}(n)
+	}
+
+	close(start)
+	wg.Wait()
+
+	registered := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			registered++
+		case !errors.Is(err, db.ErrDeviceRegistered):
+			t.Errorf("registration failed: %v", err)
+		}
+	}
+
+	if registered != 1 {
+		t.Errorf("%d registrations succeeded, want 1", registered)
+	}
+}
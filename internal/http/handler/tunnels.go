@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+func GetTunnelRelays(c *fiber.Ctx) error {
+	relays, err := db.Database.GetTunnelRelays(c.Query("region"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"relays": relays,
+	})
+}
+
+func UpsertTunnelRelay(c *fiber.Ctx) error {
+	type RequestBody struct {
+		Name    string `json:"name" validate:"required"`
+		Region  string `json:"region" validate:"required"`
+		IPv4    string `json:"ipv4" validate:"omitempty,ipv4"`
+		IPv6    string `json:"ipv6" validate:"omitempty,ipv6"`
+		Enabled *bool  `json:"enabled"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if body.IPv4 == "" && body.IPv6 == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Relay needs an IPv4 or IPv6 address"})
+	}
+
+	relay := &model.TunnelRelay{
+		Name:       body.Name,
+		Region:     strings.ToLower(body.Region),
+		ARecord:    body.IPv4,
+		AAAARecord: body.IPv6,
+		Enabled:    body.Enabled == nil || *body.Enabled,
+	}
+
+	relay, err := db.Database.UpsertTunnelRelay(relay)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Relay successfully created or updated",
+		"relay":   relay,
+	})
+}
+
+func DeleteTunnelRelay(c *fiber.Ctx) error {
+	success, err := db.Database.DeleteTunnelRelay(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !success {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Relay not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Relay successfully deleted",
+	})
+}
+
+// DrainTunnelRelay moves all devices off a relay, e.g. before maintenance.
+// The relay is disabled first so it isn't picked again.
+func DrainTunnelRelay(c *fiber.Ctx) error {
+	relay, err := db.Database.GetTunnelRelay(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if relay == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Relay not found"})
+	}
+
+	relay.Enabled = false
+	if _, err := db.Database.UpsertTunnelRelay(relay); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	moved, err := db.Database.DrainTunnelRelay(relay, strings.ToLower(c.Query("region")))
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Relay successfully drained",
+		"moved":   moved,
+	})
+}
+
+func GetTunnelEndpoints(c *fiber.Ctx) error {
+	endpoints, err := db.Database.GetTunnelEndpoints(c.Query("relay"), c.Query("uuid"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"endpoints": endpoints,
+	})
+}
+
+// AssignTunnelEndpoint points a device's tunnel name at a relay. Either a
+// relay name or a region has to be given; for a region the least loaded relay
+// of its pool is used.
+func AssignTunnelEndpoint(rc *config.Reloadable) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := rc.Get()
+
+		type RequestBody struct {
+			Domain string `json:"domain" validate:"required,fqdn"`
+			UUID   string `json:"uuid" validate:"required,len=36"`
+			Relay  string `json:"relay"`
+			Region string `json:"region"`
+		}
+
+		var body RequestBody
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var relay *model.TunnelRelay
+		var err error
+
+		if body.Relay != "" {
+			relay, err = db.Database.GetTunnelRelay(body.Relay)
+			if err == nil && relay == nil {
+				err = fmt.Errorf("relay %s not found", body.Relay)
+			}
+		} else if body.Region != "" {
+			relay, err = db.Database.PickTunnelRelay(strings.ToLower(body.Region), 0)
+		} else {
+			err = fmt.Errorf("either relay or region is required")
+		}
+
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		zone, err := requestZone(cfg, c, body.Domain)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		endpoint := &model.TunnelEndpoint{
+			Domain:  strings.ToLower(body.Domain),
+			UUID:    body.UUID,
+			RelayID: relay.ID,
+		}
+
+		_, err = db.Database.AssignTunnelEndpoint(endpoint, zone.Domain)
+		if err != nil {
+			logger.Log.Debug("Failed to assign tunnel endpoint ", err.Error())
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		endpoint, err = db.Database.GetTunnelEndpoint(endpoint.Domain)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":  "Tunnel endpoint successfully assigned",
+			"endpoint": endpoint,
+		})
+	}
+}
+
+func DeleteTunnelEndpoint(c *fiber.Ctx) error {
+	success, err := db.Database.DeleteTunnelEndpoint(strings.ToLower(c.Params("domain")))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !success {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Tunnel endpoint not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Tunnel endpoint successfully deleted",
+	})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/http/middleware"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+const (
+	maxTXTValues      = 10
+	maxTXTValueLength = 2048
+)
+
+// GetTXTRecords lists the TXT values of a name held by the device.
+func GetTXTRecords(c *fiber.Ctx) error {
+	domain := strings.ToLower(strings.TrimSuffix(c.Query("domain"), "."))
+
+	record, err := db.Database.GetRecordByDomain(domain)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if record == nil || record.UUID != middleware.DeviceUUID(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Record not found"})
+	}
+
+	values, err := db.Database.GetTXTRecords(domain)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"domain": domain,
+		"values": values,
+	})
+}
+
+// SetTXTRecords replaces the TXT values of a name held by the device. An
+// empty list removes them.
+func SetTXTRecords(c *fiber.Ctx) error {
+	type RequestBody struct {
+		Domain string   `json:"domain"`
+		Values []string `json:"values"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON sent by client"})
+	}
+
+	if len(body.Values) > maxTXTValues {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Errorf("A name can't have more than %d TXT values", maxTXTValues).Error(),
+		})
+	}
+
+	for _, value := range body.Values {
+		if err := validateTXTValue(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(body.Domain, "."))
+
+	success, err := db.Database.SetTXTRecords(middleware.DeviceUUID(c), domain, body.Values)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !success {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Record not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "TXT records successfully set",
+	})
+}
+
+// validateTXTValue only accepts printable ASCII without backslashes, which
+// the DNS library would read as escapes.
+func validateTXTValue(value string) error {
+	if value == "" || len(value) > maxTXTValueLength {
+		return fmt.Errorf("TXT values need to be between 1 and %d characters", maxTXTValueLength)
+	}
+
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e || r == '\\' {
+			return fmt.Errorf("TXT values may only contain printable ASCII characters other than backslashes")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// requestZone returns the zone given by the zone query parameter, or the zone
+// the domain falls in when the parameter is missing. The domain has to be a
+// name below the apex of that zone.
+func requestZone(cfg *config.Config, c *fiber.Ctx, domain string) (*config.Zone, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	var zone *config.Zone
+
+	if name := c.Query("zone"); name != "" {
+		zone = cfg.GetZone(name)
+		if zone == nil {
+			return nil, fmt.Errorf("Unknown zone %s", name)
+		}
+	} else {
+		zone = cfg.FindZone(domain)
+		if zone == nil {
+			return nil, fmt.Errorf("Invalid domain, needs to be a subdomain of %s", cfg.Domain)
+		}
+	}
+
+	if domain == zone.Domain || !zone.Contains(domain) {
+		return nil, fmt.Errorf("Invalid domain, needs to be a subdomain of %s", zone.Domain)
+	}
+
+	return zone, nil
+}
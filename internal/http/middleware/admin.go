@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+const (
+	adminLocal        = "admin"
+	auditDetailsLocal = "audit_details"
+)
+
+// AdminActor returns who a request to the admin API was authenticated as:
+// "token" for the admin token, or "cert:" and the common name of a client
+// certificate.
+func AdminActor(c *fiber.Ctx) string {
+	actor, _ := c.Locals(adminLocal).(string)
+	return actor
+}
+
+// SetAuditDetails attaches details to the audit log entry of a request, like
+// what a bulk action did to each name.
+func SetAuditDetails(c *fiber.Ctx, details interface{}) {
+	c.Locals(auditDetailsLocal, details)
+}
+
+// AdminAuthMiddleware lets requests through that present a client
+// certificate verified against the admin client CA, or carry the token
+// returned by token in their Authorization header. Client certificates are
+// only asked for on the admin TLS listener. Without a certificate, an empty
+// token disables the routes like BearerTokenMiddleware.
+func AdminAuthMiddleware(token func() string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if state := c.Context().TLSConnectionState(); state != nil && len(state.VerifiedChains) > 0 {
+			c.Locals(adminLocal, "cert:"+state.VerifiedChains[0][0].Subject.CommonName)
+			return c.Next()
+		}
+
+		token := token()
+		if token == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API disabled",
+			})
+		}
+
+		provided, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid token",
+			})
+		}
+
+		c.Locals(adminLocal, "token")
+
+		return c.Next()
+	}
+}
+
+// AuditMiddleware records requests in the audit log once they are answered,
+// with who made them, their outcome and the details handlers attached.
+// Failing to record a request is logged but doesn't fail it.
+func AuditMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		entry := &model.AuditEntry{
+			Actor:    AdminActor(c),
+			Method:   c.Method(),
+			Path:     c.OriginalURL(),
+			Status:   status,
+			SourceIP: c.IP(),
+		}
+
+		if details := c.Locals(auditDetailsLocal); details != nil {
+			if b, jsonErr := json.Marshal(details); jsonErr == nil {
+				entry.Details = b
+			} else {
+				logger.Log.Warn("Failed to encode audit details: ", jsonErr.Error())
+			}
+		}
+
+		if auditErr := db.Database.InsertAuditEntry(entry); auditErr != nil {
+			logger.Log.Warn("Failed to record admin request ", entry.Method, " ", entry.Path, " in the audit log")
+		}
+
+		return err
+	}
+}
@@ -1,77 +1,144 @@
 package middleware
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"github.com/DifuseHQ/dddns/internal/auth"
 	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/internal/db/model"
+	"github.com/DifuseHQ/dddns/internal/identity"
+	"github.com/DifuseHQ/dddns/pkg/logger"
 	"github.com/gofiber/fiber/v2"
-	"net/http"
+	"strings"
 )
 
-var avArr = []string{"01cded95-f9a3-5c0a-bbe5-a1346301a3d1"}
+const (
+	uuidLocal  = "uuid"
+	tokenLocal = "token"
+)
 
-func ValidateUUID(uuid string) (bool, error) {
-	for _, av := range avArr {
-		if av == uuid {
-			return true, nil
-		}
-	}
+// DeviceUUID returns the UUID of the device a request was authenticated as.
+func DeviceUUID(c *fiber.Ctx) string {
+	uuid, _ := c.Locals(uuidLocal).(string)
+	return uuid
+}
 
-	err := db.Database.QueryRow(`SELECT uuid FROM records WHERE uuid = $1`, uuid).Scan(&uuid)
+// DeviceToken returns the token a request was authenticated with, or nil when
+// it was authenticated by its uuid query parameter.
+func DeviceToken(c *fiber.Ctx) *model.DeviceToken {
+	token, _ := c.Locals(tokenLocal).(*model.DeviceToken)
+	return token
+}
 
-	if err != nil {
-		if err != sql.ErrNoRows {
-			return false, fmt.Errorf("db connection failed")
+// UUIDCheckMiddleware lets requests through whose uuid query parameter the
+// verifier accepts.
+func UUIDCheckMiddleware(verifier identity.Verifier) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uuid, problem := verifyUUID(c, verifier)
+		if problem != "" {
+			return c.JSON(fiber.Map{
+				"error": problem,
+			})
 		}
-	}
 
-	url := fmt.Sprintf("https://gin.difuse.io/harpoon/verify-identifier/%s", uuid)
-	resp, err := http.Get(url)
-	if err != nil {
-		return false, fmt.Errorf("error checking with remote db")
+		c.Locals(uuidLocal, uuid)
+
+		return c.Next()
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("invalid uuid")
+// verifyUUID returns the uuid query parameter of a request if the verifier
+// accepts it, and otherwise what's wrong with it.
+func verifyUUID(c *fiber.Ctx, verifier identity.Verifier) (string, string) {
+	uuid := c.Query("uuid")
+
+	if uuid == "" || len(uuid) != 36 {
+		return "", "Missing or invalid UUID"
 	}
 
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	valid, err := verifier.Verify(c.UserContext(), uuid)
 	if err != nil {
-		return false, fmt.Errorf("error decoding response from server")
+		logger.Log.Warn("Failed to verify UUID ", uuid, ": ", err.Error())
+		return "", "UUID verification failed"
 	}
 
-	if valid, ok := result["valid"].(bool); ok && valid {
-		return true, nil
+	if !valid {
+		return "", "Invalid UUID"
 	}
 
-	return false, nil
+	return uuid, ""
 }
 
-func UUIDCheckMiddleware(c *fiber.Ctx) error {
-	uuid := c.Query("uuid")
+// DeviceAuthMiddleware returns middleware authenticating devices by a bearer
+// token having the given scope. Without an Authorization header, requests
+// fall back to checking their uuid parameter like UUIDCheckMiddleware as long
+// as legacy returns true, and may then do anything the device can. Devices
+// holding a token can't fall back, so their token scopes always apply.
+func DeviceAuthMiddleware(verifier identity.Verifier, legacy func() bool) func(scope string) fiber.Handler {
+	return func(scope string) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			header := c.Get(fiber.HeaderAuthorization)
 
-	if uuid == "" || len(uuid) != 36 {
-		return c.JSON(fiber.Map{
-			"error": "Missing or invalid UUID",
-		})
-	} else {
-		valid, err := ValidateUUID(uuid)
+			if header == "" {
+				if !legacy() {
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+						"error": "Missing bearer token",
+					})
+				}
 
-		if err != nil {
-			return c.JSON(fiber.Map{
-				"error": err,
-			})
-		}
+				uuid, problem := verifyUUID(c, verifier)
+				if problem != "" {
+					return c.JSON(fiber.Map{
+						"error": problem,
+					})
+				}
 
-		if !valid {
-			return c.JSON(fiber.Map{
-				"error": "Invalid UUID",
-			})
+				held, err := db.Database.HasDeviceTokens(uuid)
+				if err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Token verification failed",
+					})
+				}
+
+				if held {
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+						"error": "Device is registered, authenticate with one of its tokens",
+					})
+				}
+
+				c.Locals(uuidLocal, uuid)
+
+				return c.Next()
+			}
+
+			provided, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid token",
+				})
+			}
+
+			token, err := db.Database.GetDeviceTokenByHash(auth.HashToken(provided))
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Token verification failed",
+				})
+			}
+
+			if token == nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid token",
+				})
+			}
+
+			if !auth.HasScope(token.Scopes, scope) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Token lacks the " + scope + " scope",
+				})
+			}
+
+			c.Locals(uuidLocal, token.UUID)
+			c.Locals(tokenLocal, token)
+
+			return c.Next()
 		}
 	}
-
-	return c.Next()
 }
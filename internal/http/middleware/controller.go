@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// BearerTokenMiddleware only lets requests through that carry the token
+// returned by token in their Authorization header. It is asked on every
+// request, so the token can change at runtime. An empty token disables the
+// routes entirely.
+func BearerTokenMiddleware(token func() string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := token()
+		if token == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API disabled",
+			})
+		}
+
+		provided, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid token",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerTokenMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "valid", token: "secret", header: "Bearer secret", want: fiber.StatusOK},
+		{name: "without scheme", token: "secret", header: "secret", want: fiber.StatusUnauthorized},
+		{name: "other scheme", token: "secret", header: "Basic secret", want: fiber.StatusUnauthorized},
+		{name: "lower case scheme", token: "secret", header: "bearer secret", want: fiber.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer other", want: fiber.StatusUnauthorized},
+		{name: "missing", token: "secret", want: fiber.StatusUnauthorized},
+		{name: "disabled", header: "Bearer ", want: fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", BearerTokenMiddleware(func() string { return tt.token }), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.header)
+			}
+
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
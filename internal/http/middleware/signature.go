@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureDeviceHeader    = "X-DDDNS-Device"
+	signatureTimestampHeader = "X-DDDNS-Timestamp"
+	signatureNonceHeader     = "X-DDDNS-Nonce"
+	signatureHeader          = "X-DDDNS-Signature"
+)
+
+// SignedRequestMiddleware authenticates requests carrying an
+// X-DDDNS-Signature header by the HMAC-SHA256 their device signed them with,
+// for devices that can't use TLS. The timestamp may differ from the server
+// clock by maxSkew, and every nonce is only accepted once within that window,
+// on whichever node sharing the store. Requests without a signature are
+// passed to next, the usual authentication.
+func SignedRequestMiddleware(maxSkew func() time.Duration, next fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		signature := c.Get(signatureHeader)
+		if signature == "" {
+			return next(c)
+		}
+
+		uuid := c.Get(signatureDeviceHeader)
+		timestamp := c.Get(signatureTimestampHeader)
+		nonce := c.Get(signatureNonceHeader)
+
+		if len(uuid) != 36 || len(nonce) < 8 || len(nonce) > 64 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Missing or invalid signature headers",
+			})
+		}
+
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Missing or invalid signature headers",
+			})
+		}
+
+		skew := maxSkew()
+		signedAt := time.Unix(unix, 0)
+		if d := time.Since(signedAt); d > skew || d < -skew {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Request timestamp outside the allowed clock skew",
+			})
+		}
+
+		key, err := db.Database.GetSigningKey(uuid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Signature verification failed",
+			})
+		}
+
+		stringToSign := auth.StringToSign(c.Method(), c.OriginalURL(), timestamp, nonce, c.Body())
+
+		if key == "" || !auth.VerifySignature(key, stringToSign, signature) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid signature",
+			})
+		}
+
+		// Checked last, so unsigned guesses can't use up the nonces of a device.
+		fresh, err := db.Database.UseNonce(uuid, nonce, signedAt.Add(skew))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Signature verification failed",
+			})
+		}
+
+		if !fresh {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Nonce already used",
+			})
+		}
+
+		c.Locals(uuidLocal, uuid)
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/auth"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testDevice  = "00000000-0000-4000-8000-000000000001"
+	testKey     = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPath    = "/manage-record/create-or-update?x=1"
+	testBody    = `{"domain":"a.example.com"}`
+	testMaxSkew = 30 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dddns-middleware")
+	if err != nil {
+		panic(err)
+	}
+
+	logger.InitConsoleLogger(0)
+	logger.Log.SetOutput(io.Discard)
+
+	db.InitDB("", filepath.Join(dir, "dddns.db"), true, []string{"example.com"})
+
+	if _, err := db.Database.SetSigningKey(testDevice, testKey); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// signedApp answers signed requests with the device they were signed by and
+// unsigned ones with 418, standing in for the usual authentication.
+func signedApp() *fiber.App {
+	app := fiber.New()
+
+	unsigned := func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusTeapot)
+	}
+
+	app.Post("/manage-record/create-or-update", SignedRequestMiddleware(func() time.Duration {
+		return testMaxSkew
+	}, unsigned), func(c *fiber.Ctx) error {
+		return c.SendString(DeviceUUID(c))
+	})
+
+	return app
+}
+
+type signedRequest struct {
+	device    string
+	key       string
+	path      string
+	timestamp string
+	nonce     string
+	body      string
+	// sentBody replaces the body after signing when set
+	sentBody string
+}
+
+func (r signedRequest) send(t *testing.T, app *fiber.App) (int, string) {
+	t.Helper()
+
+	stringToSign := auth.StringToSign(fiber.MethodPost, r.path, r.timestamp, r.nonce, []byte(r.body))
+
+	body := r.body
+	if r.sentBody != "" {
+		body = r.sentBody
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, r.path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(signatureDeviceHeader, r.device)
+	req.Header.Set(signatureTimestampHeader, r.timestamp)
+	req.Header.Set(signatureNonceHeader, r.nonce)
+	req.Header.Set(signatureHeader, auth.Sign(r.key, stringToSign))
+
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := io.ReadAll(res.Body)
+
+	return res.StatusCode, string(b)
+}
+
+func validRequest(nonce string) signedRequest {
+	return signedRequest{
+		device:    testDevice,
+		key:       testKey,
+		path:      testPath,
+		timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		nonce:     nonce,
+		body:      testBody,
+	}
+}
+
+func TestSignedRequest(t *testing.T) {
+	at := func(d time.Duration) string {
+		return strconv.FormatInt(time.Now().Add(d).Unix(), 10)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *signedRequest)
+		want   int
+	}{
+		{name: "valid", modify: func(r *signedRequest) {}, want: fiber.StatusOK},
+		{name: "behind within skew", modify: func(r *signedRequest) { r.timestamp = at(-testMaxSkew + 5*time.Second) }, want: fiber.StatusOK},
+		{name: "ahead within skew", modify: func(r *signedRequest) { r.timestamp = at(testMaxSkew - 5*time.Second) }, want: fiber.StatusOK},
+		{name: "too old", modify: func(r *signedRequest) { r.timestamp = at(-testMaxSkew - 5*time.Second) }, want: fiber.StatusUnauthorized},
+		{name: "too far ahead", modify: func(r *signedRequest) { r.timestamp = at(testMaxSkew + 5*time.Second) }, want: fiber.StatusUnauthorized},
+		{name: "timestamp not a number", modify: func(r *signedRequest) { r.timestamp = "yesterday" }, want: fiber.StatusUnauthorized},
+		{name: "nonce too short", modify: func(r *signedRequest) { r.nonce = "short" }, want: fiber.StatusUnauthorized},
+		{name: "nonce too long", modify: func(r *signedRequest) { r.nonce = strings.Repeat("n", 65) }, want: fiber.StatusUnauthorized},
+		{name: "invalid device", modify: func(r *signedRequest) { r.device = "device" }, want: fiber.StatusUnauthorized},
+		{name: "device without key", modify: func(r *signedRequest) { r.device = "00000000-0000-4000-8000-000000000002" }, want: fiber.StatusUnauthorized},
+		{name: "other key", modify: func(r *signedRequest) { r.key = strings.Repeat("0", 64) }, want: fiber.StatusUnauthorized},
+		{name: "body changed", modify: func(r *signedRequest) { r.sentBody = `{"domain":"b.example.com"}` }, want: fiber.StatusUnauthorized},
+	}
+
+	app := signedApp()
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validRequest(fmt.Sprintf("nonce-request-%d", i))
+			tt.modify(&r)
+
+			status, body := r.send(t, app)
+			if status != tt.want {
+				t.Fatalf("status %d, want %d: %s", status, tt.want, body)
+			}
+
+			if status == fiber.StatusOK && body != r.device {
+				t.Errorf("authenticated as %q, want %q", body, r.device)
+			}
+		})
+	}
+}
+
+func TestSignedRequestUnsigned(t *testing.T) {
+	req := httptest.NewRequest(fiber.MethodPost, testPath, strings.NewReader(testBody))
+
+	res, err := signedApp().Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != fiber.StatusTeapot {
+		t.Errorf("unsigned request got status %d, not passed on", res.StatusCode)
+	}
+}
+
+func TestSignedRequestReplay(t *testing.T) {
+	app := signedApp()
+	first := validRequest("nonce-replay")
+
+	tests := []struct {
+		name    string
+		request signedRequest
+		want    int
+	}{
+		{name: "first use", request: first, want: fiber.StatusOK},
+		{name: "replayed", request: first, want: fiber.StatusUnauthorized},
+		{name: "same nonce, new timestamp", request: func() signedRequest {
+			r := first
+			r.timestamp = strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10)
+			return r
+		}(), want: fiber.StatusUnauthorized},
+		{name: "other nonce", request: validRequest("nonce-replay-other"), want: fiber.StatusOK},
+		// An invalid signature mustn't use up the nonce of the device.
+		{name: "forged", request: func() signedRequest {
+			r := validRequest("nonce-forged")
+			r.key = strings.Repeat("0", 64)
+			return r
+		}(), want: fiber.StatusUnauthorized},
+		{name: "nonce of forged request", request: validRequest("nonce-forged"), want: fiber.StatusOK},
+	}
+
+	// Run in order, each step depends on the nonces used before.
+	for _, tt := range tests {
+		status, body := tt.request.send(t, app)
+		if status != tt.want {
+			t.Fatalf("%s: status %d, want %d: %s", tt.name, status, tt.want, body)
+		}
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		uuid    string
+		nonce   string
+		expires time.Time
+		want    bool
+	}{
+		{name: "new", uuid: testDevice, nonce: "nonce-use", expires: now.Add(time.Minute), want: true},
+		{name: "reused", uuid: testDevice, nonce: "nonce-use", expires: now.Add(time.Minute), want: false},
+		{name: "other device", uuid: "00000000-0000-4000-8000-000000000002", nonce: "nonce-use", expires: now.Add(time.Minute), want: true},
+		{name: "expiring", uuid: testDevice, nonce: "nonce-expired", expires: now.Add(-time.Minute), want: true},
+		{name: "reused after expiry", uuid: testDevice, nonce: "nonce-expired", expires: now.Add(time.Minute), want: true},
+		{name: "reused again", uuid: testDevice, nonce: "nonce-expired", expires: now.Add(time.Minute), want: false},
+	}
+
+	for _, tt := range tests {
+		got, err := db.Database.UseNonce(tt.uuid, tt.nonce, tt.expires)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: UseNonce() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
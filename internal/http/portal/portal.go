@@ -0,0 +1,32 @@
+// Package portal serves the self-service portal for device owners. It is a
+// static page embedded into the binary that manages the names of a device
+// through the device API, authenticated by one of its tokens.
+package portal
+
+import (
+	"embed"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the portal, mounted with Use below /portal. Scripts and
+// styles may only come from the portal itself.
+func Handler() fiber.Handler {
+	files := filesystem.New(filesystem.Config{
+		Root:       http.FS(static),
+		PathPrefix: "static",
+		Index:      "index.html",
+	})
+
+	return func(c *fiber.Ctx) error {
+		c.Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'")
+		c.Set("X-Content-Type-Options", "nosniff")
+		c.Set("Referrer-Policy", "no-referrer")
+
+		return files(c)
+	}
+}
@@ -0,0 +1,210 @@
+'use strict';
+
+// The portal manages the names of one device through the device API, signed
+// in with one of its tokens. What a token may change depends on its scopes;
+// the API tells when it lacks one.
+
+const state = {
+	token: sessionStorage.getItem('dddns-device-token') || '',
+	checked: '',
+};
+
+const $ = (id) => document.getElementById(id);
+
+class AuthError extends Error {}
+
+async function api(path, options = {}) {
+	const headers = Object.assign({ Authorization: 'Bearer ' + state.token }, options.headers);
+	if (options.body) {
+		headers['Content-Type'] = 'application/json';
+	}
+
+	const res = await fetch(path, Object.assign({}, options, { headers }));
+	if (res.status === 401) {
+		throw new AuthError('Not signed in');
+	}
+
+	const body = await res.json().catch(() => ({}));
+	if (!res.ok || body.error) {
+		throw new Error(body.error || res.statusText);
+	}
+
+	return body;
+}
+
+function setStatus(node, message, ok) {
+	node.textContent = message;
+	node.className = node.className.replace(/ ?(ok|error)$/, '') + (ok ? ' ok' : ' error');
+}
+
+function showError(err) {
+	if (err instanceof AuthError) {
+		signOut();
+		return;
+	}
+	$('error').textContent = err ? err.message : '';
+}
+
+// Sign in
+
+function signOut() {
+	state.token = '';
+	sessionStorage.removeItem('dddns-device-token');
+	$('device').hidden = true;
+	$('logout').hidden = true;
+	$('summary').textContent = '';
+	$('login').hidden = false;
+}
+
+$('login-form').addEventListener('submit', async (event) => {
+	event.preventDefault();
+	state.token = $('login-token').value.trim();
+
+	try {
+		await api('/manage-record/list');
+	} catch (err) {
+		$('login-error').textContent = err instanceof AuthError ? 'Invalid token' : err.message;
+		state.token = '';
+		return;
+	}
+
+	sessionStorage.setItem('dddns-device-token', state.token);
+	$('login-token').value = '';
+	$('login-error').textContent = '';
+	load();
+});
+
+$('logout').addEventListener('click', signOut);
+
+// Names
+
+async function load() {
+	if (!state.token) {
+		signOut();
+		return;
+	}
+
+	try {
+		const list = await api('/manage-record/list');
+		const txt = await Promise.all(list.records.map((record) =>
+			api('/manage-record/txt?' + new URLSearchParams({ domain: record.Domain }))));
+
+		$('names').replaceChildren(...list.records.map((record, i) =>
+			renderName(record, txt[i].values, list.query_counts[record.Domain] || 0)));
+
+		if (list.records.length === 0) {
+			$('names').textContent = 'The device holds no names yet.';
+		}
+
+		$('summary').textContent = list.records.length + ' of ' + list.quota + ' names';
+		$('login').hidden = true;
+		$('device').hidden = false;
+		$('logout').hidden = false;
+		showError(null);
+	} catch (err) {
+		showError(err);
+	}
+}
+
+function renderName(record, txtValues, queries) {
+	const node = $('name-template').content.firstElementChild.cloneNode(true);
+	const status = node.querySelector('.status');
+
+	node.querySelector('.domain').textContent = record.Domain;
+	node.querySelector('.queries').textContent = queries + ' queries (this node, since start)';
+	node.querySelector('.updated').textContent = 'Last updated ' + new Date(record.LastUpdateAt).toLocaleString();
+
+	const addressForm = node.querySelector('.address-form');
+	addressForm.ipv4.value = record.ARecord;
+	addressForm.ipv6.value = record.AAAARecord;
+	addressForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+		try {
+			await api('/manage-record/create-or-update', {
+				method: 'POST',
+				body: JSON.stringify({
+					domain: record.Domain,
+					ipv4: addressForm.ipv4.value.trim(),
+					ipv6: addressForm.ipv6.value.trim(),
+					alias: record.Alias,
+				}),
+			});
+			setStatus(status, 'Addresses saved', true);
+		} catch (err) {
+			handleFormError(status, err);
+		}
+	});
+
+	const txtForm = node.querySelector('.txt-form');
+	txtForm.txt.value = txtValues.join('\n');
+	txtForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+		const values = txtForm.txt.value.split('\n').map((line) => line.trim()).filter((line) => line !== '');
+		try {
+			await api('/manage-record/txt', {
+				method: 'PUT',
+				body: JSON.stringify({ domain: record.Domain, values }),
+			});
+			setStatus(status, 'TXT records saved', true);
+		} catch (err) {
+			handleFormError(status, err);
+		}
+	});
+
+	return node;
+}
+
+function handleFormError(status, err) {
+	if (err instanceof AuthError) {
+		signOut();
+		return;
+	}
+	setStatus(status, err.message, false);
+}
+
+// Availability
+
+$('check-form').addEventListener('submit', async (event) => {
+	event.preventDefault();
+	const name = $('check-name').value.trim().toLowerCase().replace(/\.$/, '');
+	const result = $('check-result');
+
+	$('add-form').hidden = true;
+
+	try {
+		const check = await api('/checks/is-domain-available/' + encodeURIComponent(name));
+		if (check.available) {
+			state.checked = name;
+			setStatus(result, name + ' is available', true);
+			$('add-form').hidden = false;
+		} else {
+			setStatus(result, name + ' is taken', false);
+		}
+	} catch (err) {
+		handleFormError(result, err);
+	}
+});
+
+$('add-form').addEventListener('submit', async (event) => {
+	event.preventDefault();
+	const result = $('check-result');
+
+	try {
+		await api('/manage-record/create-or-update', {
+			method: 'POST',
+			body: JSON.stringify({
+				domain: state.checked,
+				ipv4: $('add-ipv4').value.trim(),
+				ipv6: $('add-ipv6').value.trim(),
+			}),
+		});
+		$('add-form').hidden = true;
+		$('check-name').value = '';
+		setStatus(result, state.checked + ' added', true);
+		load();
+	} catch (err) {
+		handleFormError(result, err);
+	}
+});
+
+load();
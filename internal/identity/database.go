@@ -0,0 +1,22 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+)
+
+// DatabaseVerifier accepts the devices registered in the store, either set up
+// through the admin API or accepted before and holding names.
+type DatabaseVerifier struct {
+	Store db.Store
+}
+
+func (v *DatabaseVerifier) Verify(ctx context.Context, uuid string) (bool, error) {
+	device, err := v.Store.GetDevice(uuid)
+	if err != nil {
+		return false, fmt.Errorf("db connection failed")
+	}
+
+	return device != nil, nil
+}
@@ -0,0 +1,299 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const guardCacheSize = 65536
+
+// errCircuitOpen is returned while the upstream is given time to recover.
+var errCircuitOpen = errors.New("identity verification circuit is open")
+
+// GuardSettings configure how a Guard asks its upstream verifier.
+type GuardSettings struct {
+	// PositiveTTL and NegativeTTL are how long accepted and rejected UUIDs
+	// are cached, 0 not caching them.
+	PositiveTTL time.Duration
+	NegativeTTL time.Duration
+	// BreakerFailures is the number of failures in a row after which the
+	// upstream isn't asked for BreakerCooldown.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// FailOpen accepts UUIDs the upstream can't be asked about instead of
+	// failing their requests.
+	FailOpen bool
+}
+
+// GuardStats are the counters of a Guard since it was created.
+type GuardStats struct {
+	CacheHits          int64   `json:"cache_hits"`
+	CacheMisses        int64   `json:"cache_misses"`
+	CacheHitRate       float64 `json:"cache_hit_rate"`
+	CacheSize          int     `json:"cache_size"`
+	UpstreamRequests   int64   `json:"upstream_requests"`
+	UpstreamErrors     int64   `json:"upstream_errors"`
+	UpstreamLatencyAvg float64 `json:"upstream_latency_avg_ms"`
+	UpstreamLatencyMax float64 `json:"upstream_latency_max_ms"`
+	CircuitState       string  `json:"circuit_state"`
+	CircuitOpened      int64   `json:"circuit_opened"`
+	FailOpenAccepts    int64   `json:"fail_open_accepts"`
+}
+
+type guardEntry struct {
+	valid   bool
+	expires time.Time
+}
+
+// Guard keeps a slow upstream verifier off the request path: it caches its
+// answers, asks it once for concurrent requests of the same UUID and stops
+// asking it for a while after it failed repeatedly. It lives across config
+// reloads, which only replace its settings and upstream.
+type Guard struct {
+	mu          sync.Mutex
+	upstream    Verifier
+	upstreamKey string
+	settings    GuardSettings
+	entries     map[string]guardEntry
+	failures    int
+	openUntil   time.Time
+	trial       bool
+
+	group singleflight.Group
+
+	cacheHits       atomic.Int64
+	cacheMisses     atomic.Int64
+	requests        atomic.Int64
+	errors          atomic.Int64
+	latencyTotal    atomic.Int64
+	latencyMax      atomic.Int64
+	circuitOpened   atomic.Int64
+	failOpenAccepts atomic.Int64
+}
+
+func NewGuard() *Guard {
+	return &Guard{entries: make(map[string]guardEntry)}
+}
+
+// Configure sets the upstream and settings. The cache and the circuit are
+// reset when key, which identifies the upstream, changes.
+func (g *Guard) Configure(upstream Verifier, key string, settings GuardSettings) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if key != g.upstreamKey {
+		g.entries = make(map[string]guardEntry)
+		g.failures = 0
+		g.openUntil = time.Time{}
+		g.trial = false
+	}
+
+	g.upstream = upstream
+	g.upstreamKey = key
+	g.settings = settings
+}
+
+func (g *Guard) Verify(ctx context.Context, uuid string) (bool, error) {
+	uuid = normalize(uuid)
+
+	if valid, ok := g.cached(uuid); ok {
+		g.cacheHits.Add(1)
+		return valid, nil
+	}
+	g.cacheMisses.Add(1)
+
+	// The first request asks for everyone waiting, so it mustn't cancel the
+	// others when it goes away.
+	ctx = context.WithoutCancel(ctx)
+
+	result, err, _ := g.group.Do(uuid, func() (interface{}, error) {
+		return g.ask(ctx, uuid)
+	})
+	if err != nil {
+		if g.failOpen() {
+			g.failOpenAccepts.Add(1)
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return result.(bool), nil
+}
+
+func (g *Guard) ask(ctx context.Context, uuid string) (bool, error) {
+	upstream, ok := g.allow()
+	if !ok {
+		return false, errCircuitOpen
+	}
+
+	start := time.Now()
+	valid, err := upstream.Verify(ctx, uuid)
+	g.observe(time.Since(start))
+
+	g.record(err)
+
+	if err != nil {
+		g.errors.Add(1)
+		return false, err
+	}
+
+	g.store(uuid, valid)
+
+	return valid, nil
+}
+
+func (g *Guard) cached(uuid string) (bool, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry, ok := g.entries[uuid]
+	if !ok || time.Now().After(entry.expires) {
+		return false, false
+	}
+
+	return entry.valid, true
+}
+
+func (g *Guard) store(uuid string, valid bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ttl := g.settings.NegativeTTL
+	if valid {
+		ttl = g.settings.PositiveTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	if len(g.entries) >= guardCacheSize {
+		g.pruneLocked()
+		if len(g.entries) >= guardCacheSize {
+			return
+		}
+	}
+
+	g.entries[uuid] = guardEntry{valid: valid, expires: time.Now().Add(ttl)}
+}
+
+// allow returns the upstream unless the circuit is open. Once the cooldown is
+// over, a single request is let through to find out whether the upstream
+// recovered.
+func (g *Guard) allow() (Verifier, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.upstream == nil {
+		return nil, false
+	}
+
+	if !g.openUntil.IsZero() {
+		if time.Now().Before(g.openUntil) || g.trial {
+			return nil, false
+		}
+		g.trial = true
+	}
+
+	return g.upstream, true
+}
+
+func (g *Guard) record(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.trial = false
+
+	if err == nil {
+		g.failures = 0
+		g.openUntil = time.Time{}
+		return
+	}
+
+	g.failures++
+
+	if g.settings.BreakerFailures > 0 && g.failures >= g.settings.BreakerFailures {
+		if g.openUntil.IsZero() || time.Now().After(g.openUntil) {
+			g.circuitOpened.Add(1)
+		}
+		g.openUntil = time.Now().Add(g.settings.BreakerCooldown)
+	}
+}
+
+func (g *Guard) observe(latency time.Duration) {
+	g.requests.Add(1)
+	g.latencyTotal.Add(int64(latency))
+
+	for {
+		max := g.latencyMax.Load()
+		if int64(latency) <= max || g.latencyMax.CompareAndSwap(max, int64(latency)) {
+			return
+		}
+	}
+}
+
+func (g *Guard) failOpen() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.settings.FailOpen
+}
+
+// Prune drops expired cache entries, run periodically in the background.
+func (g *Guard) Prune() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pruneLocked()
+}
+
+func (g *Guard) pruneLocked() {
+	now := time.Now()
+
+	for uuid, entry := range g.entries {
+		if now.After(entry.expires) {
+			delete(g.entries, uuid)
+		}
+	}
+}
+
+func (g *Guard) Stats() GuardStats {
+	stats := GuardStats{
+		CacheHits:        g.cacheHits.Load(),
+		CacheMisses:      g.cacheMisses.Load(),
+		UpstreamRequests: g.requests.Load(),
+		UpstreamErrors:   g.errors.Load(),
+		CircuitOpened:    g.circuitOpened.Load(),
+		FailOpenAccepts:  g.failOpenAccepts.Load(),
+	}
+
+	if total := stats.CacheHits + stats.CacheMisses; total > 0 {
+		stats.CacheHitRate = float64(stats.CacheHits) / float64(total)
+	}
+
+	if stats.UpstreamRequests > 0 {
+		stats.UpstreamLatencyAvg = float64(g.latencyTotal.Load()) / float64(stats.UpstreamRequests) / float64(time.Millisecond)
+	}
+	stats.UpstreamLatencyMax = float64(g.latencyMax.Load()) / float64(time.Millisecond)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats.CacheSize = len(g.entries)
+
+	switch {
+	case g.openUntil.IsZero():
+		stats.CircuitState = "closed"
+	case time.Now().Before(g.openUntil):
+		stats.CircuitState = "open"
+	default:
+		stats.CircuitState = "half-open"
+	}
+
+	return stats
+}
@@ -0,0 +1,109 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DifuseHQ/dddns/internal/db"
+	"github.com/DifuseHQ/dddns/pkg/config"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Verifier decides whether a device UUID may use the API. It returns an
+// error when it can't tell, like when a service it asks is unreachable.
+type Verifier interface {
+	Verify(ctx context.Context, uuid string) (bool, error)
+}
+
+// Chain asks its verifiers in order and accepts a UUID as soon as one of them
+// does. Errors of verifiers are only returned when no other one accepted it.
+type Chain []Verifier
+
+func (c Chain) Verify(ctx context.Context, uuid string) (bool, error) {
+	var errs []error
+
+	for _, verifier := range c {
+		valid, err := verifier.Verify(ctx, uuid)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if valid {
+			return true, nil
+		}
+	}
+
+	return false, errors.Join(errs...)
+}
+
+// Swappable verifies with a verifier that can be replaced while requests are
+// being verified, like on config reloads.
+type Swappable struct {
+	current atomic.Pointer[Chain]
+}
+
+// Set replaces the verifiers used.
+func (s *Swappable) Set(verifier Chain) {
+	s.current.Store(&verifier)
+}
+
+func (s *Swappable) Verify(ctx context.Context, uuid string) (bool, error) {
+	verifier := s.current.Load()
+	if verifier == nil {
+		return false, fmt.Errorf("no identity verifier set up")
+	}
+
+	return verifier.Verify(ctx, uuid)
+}
+
+// FromConfig builds the chain of verifiers named in identity_verifiers
+// without putting anything into effect. The apply function it returns, which
+// can't fail, makes verifier use the chain and sets up guard for the remote
+// verifier, so a reload can build the chain before the rest of the config
+// and only apply it once all of it could be.
+func FromConfig(cfg *config.Config, store db.Store, guard *Guard, verifier *Swappable) (func(), error) {
+	var chain Chain
+	var remote Verifier
+
+	for _, name := range cfg.GetIdentityVerifiers() {
+		switch name {
+		case "remote":
+			remote = NewRemoteVerifier(cfg.IdentityURL, cfg.IdentityField, time.Duration(cfg.IdentityTimeout)*time.Second)
+			chain = append(chain, guard)
+		case "allowlist":
+			allowlist, err := LoadAllowlist(cfg.IdentityAllowlistPath)
+			if err != nil {
+				return nil, fmt.Errorf("loading identity allowlist: %w", err)
+			}
+			chain = append(chain, allowlist)
+		case "database":
+			chain = append(chain, &DatabaseVerifier{Store: store})
+		default:
+			return nil, fmt.Errorf("unknown identity verifier %s", name)
+		}
+	}
+
+	apply := func() {
+		if remote != nil {
+			guard.Configure(remote, cfg.IdentityURL+" "+cfg.IdentityField, GuardSettings{
+				PositiveTTL:     time.Duration(cfg.IdentityCacheTTL) * time.Second,
+				NegativeTTL:     time.Duration(cfg.IdentityNegativeCacheTTL) * time.Second,
+				BreakerFailures: cfg.IdentityBreakerFailures,
+				BreakerCooldown: time.Duration(cfg.IdentityBreakerCooldown) * time.Second,
+				FailOpen:        cfg.IdentityFailOpen,
+			})
+		}
+
+		verifier.Set(chain)
+	}
+
+	return apply, nil
+}
+
+// normalize lowercases a UUID, which are compared case-insensitively.
+func normalize(uuid string) string {
+	return strings.ToLower(strings.TrimSpace(uuid))
+}
@@ -0,0 +1,73 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RemoteVerifier asks an HTTP service about UUIDs. The service is requested at
+// a URL with {uuid} replaced by the UUID and accepts it by answering 200 with
+// a JSON object whose field is true. The field can be nested, like data.valid.
+type RemoteVerifier struct {
+	URL    string
+	Field  string
+	Client *http.Client
+}
+
+func NewRemoteVerifier(urlTemplate string, field string, timeout time.Duration) *RemoteVerifier {
+	return &RemoteVerifier{
+		URL:    urlTemplate,
+		Field:  field,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (v *RemoteVerifier) Verify(ctx context.Context, uuid string) (bool, error) {
+	target := strings.ReplaceAll(v.URL, "{uuid}", url.PathEscape(uuid))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, fmt.Errorf("error building identity request: %w", err)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error checking with remote db: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return false, fmt.Errorf("remote db answered with status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("error decoding response from server")
+	}
+
+	return lookupField(result, v.Field) == true, nil
+}
+
+// lookupField returns the value at a dotted path of a JSON object, or nil.
+func lookupField(object map[string]interface{}, path string) interface{} {
+	var value interface{} = object
+
+	for _, key := range strings.Split(path, ".") {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = nested[key]
+	}
+
+	return value
+}
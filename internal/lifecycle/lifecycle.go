@@ -0,0 +1,195 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Service is a long running server. Serve blocks until the service fails or
+// is shut down, in which case it returns nil.
+type Service interface {
+	Name() string
+	Serve() error
+	Shutdown(ctx context.Context) error
+}
+
+// ServiceFunc adapts a pair of functions to a Service.
+type ServiceFunc struct {
+	ServiceName  string
+	ServeFunc    func() error
+	ShutdownFunc func(ctx context.Context) error
+}
+
+func (f ServiceFunc) Name() string                       { return f.ServiceName }
+func (f ServiceFunc) Serve() error                       { return f.ServeFunc() }
+func (f ServiceFunc) Shutdown(ctx context.Context) error { return f.ShutdownFunc(ctx) }
+
+type worker struct {
+	name     string
+	interval time.Duration
+	run      func()
+}
+
+type closer struct {
+	name  string
+	close func() error
+}
+
+type exit struct {
+	name string
+	err  error
+}
+
+// Manager starts services and background workers, and on SIGINT/SIGTERM or
+// the failure of any service shuts them down in reverse order, giving
+// in-flight requests until the timeout to finish before running the closers.
+// SIGHUP runs the reloaders instead.
+type Manager struct {
+	timeout   time.Duration
+	services  []Service
+	workers   []worker
+	closers   []closer
+	reloaders []func()
+}
+
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout}
+}
+
+func (m *Manager) AddService(service Service) {
+	m.services = append(m.services, service)
+}
+
+// AddWorker runs fn every interval while the services are up.
+func (m *Manager) AddWorker(name string, interval time.Duration, fn func()) {
+	m.workers = append(m.workers, worker{name: name, interval: interval, run: fn})
+}
+
+// AddCloser registers fn to run once all services have stopped. Closers run
+// in the order they were added.
+func (m *Manager) AddCloser(name string, fn func() error) {
+	m.closers = append(m.closers, closer{name: name, close: fn})
+}
+
+// OnReload registers fn to run on SIGHUP.
+func (m *Manager) OnReload(fn func()) {
+	m.reloaders = append(m.reloaders, fn)
+}
+
+// Run blocks until the process is asked to stop and everything has been shut
+// down. It returns the error of the service that failed, if any.
+func (m *Manager) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	exits := make(chan exit, len(m.services))
+	for _, service := range m.services {
+		go func(service Service) {
+			exits <- exit{name: service.Name(), err: service.Serve()}
+		}(service)
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	for _, w := range m.workers {
+		workers.Add(1)
+		go func(w worker) {
+			defer workers.Done()
+			runWorker(workerCtx, w)
+		}(w)
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var runErr error
+	running := len(m.services)
+
+run:
+	for {
+		select {
+		case <-hangup:
+			logger.Log.Info("Received reload signal")
+			for _, reload := range m.reloaders {
+				reload()
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Received shutdown signal")
+			break run
+		case e := <-exits:
+			running--
+			if e.err != nil {
+				runErr = fmt.Errorf("%s: %w", e.name, e.err)
+				logger.Log.Error("Service ", e.name, " failed: ", e.err)
+			} else {
+				runErr = fmt.Errorf("%s stopped unexpectedly", e.name)
+				logger.Log.Error("Service ", e.name, " stopped unexpectedly")
+			}
+			break run
+		}
+	}
+
+	stop()
+	signal.Stop(hangup)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	for i := len(m.services) - 1; i >= 0; i-- {
+		service := m.services[i]
+		logger.Log.Info("Shutting down ", service.Name())
+
+		if err := service.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Warn("Shutdown of ", service.Name(), " incomplete: ", err)
+		}
+	}
+
+wait:
+	for running > 0 {
+		select {
+		case e := <-exits:
+			running--
+			if e.err != nil {
+				logger.Log.Warn("Service ", e.name, " stopped with error: ", e.err)
+			}
+		case <-shutdownCtx.Done():
+			logger.Log.Warn("Timed out waiting for services to stop")
+			break wait
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	for _, c := range m.closers {
+		if err := c.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close %s: %s\n", c.name, err)
+			if runErr == nil {
+				runErr = err
+			}
+		}
+	}
+
+	return runErr
+}
+
+func runWorker(ctx context.Context, w worker) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.run()
+		}
+	}
+}
@@ -1,77 +1,314 @@
 package config
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/DifuseHQ/dddns/pkg/logger"
+	"net/url"
 	"os"
+	"strings"
 )
 
 const AppVersion string = "v1.0.0"
 
 type Config struct {
-	DBPath           string `json:"db_path"`
-	LogPath          string `json:"log_path"`
-	DNSAddr          string `json:"dns_addr"`
-	DNSPort          string `json:"dns_port"`
-	HTTPAddr         string `json:"http_addr"`
-	HTTPPort         string `json:"http_port"`
-	Domain           string `json:"domain"`
-	NameServerDomain string `json:"name_server_domain"`
-	MailBox          string `json:"mail_box"`
-	Authoritative    bool   `json:"authoritative"`
-	LogLevel         int    `json:"log_level"`
-	TunnelARecord    string `json:"tunnel_a_record"`
-	TunnelAAAARecord string `json:"tunnel_aaaa_record"`
+	DBPath                   string                              `json:"db_path"`
+	DBDSN                    string                              `json:"db_dsn"`
+	AutoMigrate              bool                                `json:"auto_migrate"`
+	LogPath                  string                              `json:"log_path"`
+	DNSAddr                  string                              `json:"dns_addr"`
+	DNSPort                  string                              `json:"dns_port"`
+	HTTPAddr                 string                              `json:"http_addr"`
+	HTTPPort                 string                              `json:"http_port"`
+	Domain                   string                              `json:"domain"`
+	NameServerDomain         string                              `json:"name_server_domain"`
+	NameServers              []NameServer                        `json:"name_servers"`
+	MailBox                  string                              `json:"mail_box"`
+	Authoritative            bool                                `json:"authoritative"`
+	LogLevel                 int                                 `json:"log_level"`
+	TunnelARecord            string                              `json:"tunnel_a_record"`
+	TunnelAAAARecord         string                              `json:"tunnel_aaaa_record"`
+	TunnelToken              string                              `json:"tunnel_controller_token"`
+	GeoMapPath               string                              `json:"geo_map_path"`
+	GeoRecords               map[string]map[string]GeoAddressSet `json:"geo_records"`
+	TSIGKeys                 map[string]string                   `json:"tsig_keys"`
+	Views                    []View                              `json:"views"`
+	Zones                    []Zone                              `json:"zones"`
+	AliasUpstream            string                              `json:"alias_upstream"`
+	ChaosVersion             string                              `json:"chaos_version"`
+	ChaosHostname            string                              `json:"chaos_hostname"`
+	ChaosID                  string                              `json:"chaos_id"`
+	Cookies                  bool                                `json:"cookies"`
+	CookieSecret             string                              `json:"cookie_secret"`
+	CookieRotation           int                                 `json:"cookie_rotation"`
+	RRLRate                  int                                 `json:"rrl_responses_per_second"`
+	RRLSlip                  int                                 `json:"rrl_slip"`
+	ShutdownTimeout          int                                 `json:"shutdown_timeout"`
+	RecordTTL                int                                 `json:"record_ttl"`
+	AdminToken               string                              `json:"admin_token"`
+	AdminTLSPort             string                              `json:"admin_tls_port"`
+	AdminTLSCertPath         string                              `json:"admin_tls_cert_path"`
+	AdminTLSKeyPath          string                              `json:"admin_tls_key_path"`
+	AdminClientCAPath        string                              `json:"admin_client_ca_path"`
+	MaxNames                 int                                 `json:"max_names"`
+	Plans                    map[string]Plan                     `json:"plans"`
+	IdentityVerifiers        string                              `json:"identity_verifiers"`
+	IdentityURL              string                              `json:"identity_url"`
+	IdentityField            string                              `json:"identity_field"`
+	IdentityTimeout          int                                 `json:"identity_timeout"`
+	IdentityAllowlistPath    string                              `json:"identity_allowlist_path"`
+	IdentityCacheTTL         int                                 `json:"identity_cache_ttl"`
+	IdentityNegativeCacheTTL int                                 `json:"identity_negative_cache_ttl"`
+	IdentityBreakerFailures  int                                 `json:"identity_breaker_failures"`
+	IdentityBreakerCooldown  int                                 `json:"identity_breaker_cooldown"`
+	IdentityFailOpen         bool                                `json:"identity_fail_open"`
+	LegacyUUIDAuth           bool                                `json:"legacy_uuid_auth"`
+	SignatureMaxSkew         int                                 `json:"signature_max_skew"`
 }
 
-func InitConfig() Config {
-	var cfg Config
-	configPath := flag.String("config", "", "Path to JSON config file")
+// View is a split-horizon view, selected for clients in one of its networks
+// or signing their queries with one of its TSIG keys.
+type View struct {
+	Name     string   `json:"name"`
+	Networks []string `json:"networks"`
+	TSIGKeys []string `json:"tsig_keys"`
+}
+
+// Plan holds the limits of the devices on a plan.
+type Plan struct {
+	MaxNames int `json:"max_names"`
+}
+
+// GeoAddressSet holds the addresses a name resolves to in one region.
+type GeoAddressSet struct {
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+}
+
+// defaultConfig returns the settings used when neither the config file, the
+// environment nor the command line set them.
+func defaultConfig() Config {
+	return Config{
+		DBPath:                   "./data/dddns.db",
+		AutoMigrate:              true,
+		LogPath:                  "./data/dddns.log",
+		DNSAddr:                  "::",
+		DNSPort:                  "5544",
+		HTTPAddr:                 "::",
+		HTTPPort:                 "3000",
+		Domain:                   "difusedns.com",
+		NameServerDomain:         "ns1.difuse.io",
+		MailBox:                  "admin.difusedns.com",
+		Authoritative:            true,
+		LogLevel:                 0,
+		TunnelARecord:            "0.0.0.0",
+		TunnelAAAARecord:         "::",
+		Cookies:                  true,
+		CookieRotation:           86400,
+		RRLSlip:                  2,
+		ShutdownTimeout:          15,
+		RecordTTL:                60,
+		MaxNames:                 1,
+		IdentityVerifiers:        "remote",
+		IdentityURL:              "https://gin.difuse.io/harpoon/verify-identifier/{uuid}",
+		IdentityField:            "valid",
+		IdentityTimeout:          5,
+		IdentityCacheTTL:         300,
+		IdentityNegativeCacheTTL: 60,
+		IdentityBreakerFailures:  5,
+		IdentityBreakerCooldown:  30,
+		LegacyUUIDAuth:           true,
+		SignatureMaxSkew:         300,
+	}
+}
+
+// InitConfig loads the config from the command line of the process and exits
+// when it is invalid.
+func InitConfig() *Reloadable {
+	r, err := Load(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	return r
+}
+
+// Load adds the config options to fs, parses args with it and builds the
+// config from the defaults, the config file, DDDNS_* environment variables and
+// the flags, in increasing order of precedence. Reload repeats this with the
+// same flags. Flags of fs that aren't config options are left to the caller.
+func Load(fs *flag.FlagSet, args []string) (*Reloadable, error) {
+	defaults := defaultConfig()
+
+	var flagCfg Config
+	configPath := fs.String("config", "", "Path to a JSON, YAML or TOML config file")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), logger.AsciiArt)
+		fmt.Fprintf(fs.Output(), "Usage: %s [options]\n\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "Commands:\n")
+		fmt.Fprintf(fs.Output(), "  config check  Validate the config and print the effective settings\n")
+		fmt.Fprintf(fs.Output(), "  migrate       Apply pending database migrations (-dry-run prints them)\n\n")
+		fmt.Fprintf(fs.Output(), "Every option can also be set in the config file, or as a DDDNS_* environment\n")
+		fmt.Fprintf(fs.Output(), "variable (e.g. DDDNS_DNS_PORT). Flags take precedence over the environment,\n")
+		fmt.Fprintf(fs.Output(), "which takes precedence over the config file.\n\n")
+		fmt.Fprintf(fs.Output(), "Configuration options:\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&flagCfg.DBPath, "db-path", defaults.DBPath, "SQLite database path")
+	fs.StringVar(&flagCfg.DBDSN, "db-dsn", defaults.DBDSN, "PostgreSQL connection URL (postgres://...), replaces the SQLite database when set")
+	fs.BoolVar(&flagCfg.AutoMigrate, "auto-migrate", defaults.AutoMigrate, "Apply pending database migrations on startup")
+	fs.StringVar(&flagCfg.LogPath, "log-path", defaults.LogPath, "Log file path")
+	fs.StringVar(&flagCfg.DNSAddr, "dns-addr", defaults.DNSAddr, "DNS server bind address")
+	fs.StringVar(&flagCfg.DNSPort, "dns-port", defaults.DNSPort, "DNS server port")
+	fs.StringVar(&flagCfg.HTTPAddr, "http-addr", defaults.HTTPAddr, "HTTP server bind address")
+	fs.StringVar(&flagCfg.HTTPPort, "http-port", defaults.HTTPPort, "HTTP server port")
+	fs.StringVar(&flagCfg.Domain, "domain", defaults.Domain, "Domain to use for DNS records")
+	fs.StringVar(&flagCfg.NameServerDomain, "name-server-domain", defaults.NameServerDomain, "Domain to use for name server records")
+	fs.StringVar(&flagCfg.MailBox, "mail-box", defaults.MailBox, "Mail box to use for SOA records")
+	fs.BoolVar(&flagCfg.Authoritative, "authoritative", defaults.Authoritative, "Whether or not to be authoritative for the domain")
+	fs.IntVar(&flagCfg.LogLevel, "log-level", defaults.LogLevel, "Log level (0-1)")
+	fs.StringVar(&flagCfg.TunnelARecord, "tunnel-a-record", defaults.TunnelARecord, "A record to use for tunneling")
+	fs.StringVar(&flagCfg.TunnelAAAARecord, "tunnel-aaaa-record", defaults.TunnelAAAARecord, "AAAA record to use for tunneling")
+	fs.StringVar(&flagCfg.GeoMapPath, "geo-map-path", defaults.GeoMapPath, "Path to a CSV file mapping CIDRs to regions for GeoDNS")
+	fs.StringVar(&flagCfg.AliasUpstream, "alias-upstream", defaults.AliasUpstream, "Resolver (host:port) used to resolve ALIAS record targets")
+	fs.StringVar(&flagCfg.ChaosVersion, "chaos-version", defaults.ChaosVersion, "Answer to CHAOS version.bind queries (empty refuses them)")
+	fs.StringVar(&flagCfg.ChaosHostname, "chaos-hostname", defaults.ChaosHostname, "Answer to CHAOS hostname.bind queries (empty refuses them)")
+	fs.StringVar(&flagCfg.ChaosID, "chaos-id", defaults.ChaosID, "Answer to CHAOS id.server queries (empty refuses them)")
+	fs.BoolVar(&flagCfg.Cookies, "cookies", defaults.Cookies, "Whether or not to support DNS cookies")
+	fs.StringVar(&flagCfg.CookieSecret, "cookie-secret", defaults.CookieSecret, "Hex encoded secret for server cookies, shared by all nodes (random when empty)")
+	fs.IntVar(&flagCfg.CookieRotation, "cookie-rotation", defaults.CookieRotation, "Seconds after which the server cookie secret is rotated")
+	fs.IntVar(&flagCfg.RRLRate, "rrl-responses-per-second", defaults.RRLRate, "Responses per second allowed per client network over UDP (0 disables rate limiting)")
+	fs.IntVar(&flagCfg.RRLSlip, "rrl-slip", defaults.RRLSlip, "Send every n-th rate limited response truncated instead of dropping it (0 never does)")
+	fs.IntVar(&flagCfg.ShutdownTimeout, "shutdown-timeout", defaults.ShutdownTimeout, "Seconds to wait for in-flight requests when shutting down")
+	fs.StringVar(&flagCfg.TunnelToken, "tunnel-controller-token", defaults.TunnelToken, "Bearer token for the tunnel controller API (empty disables it)")
+	fs.IntVar(&flagCfg.RecordTTL, "record-ttl", defaults.RecordTTL, "TTL in seconds of the records served")
+	fs.StringVar(&flagCfg.AdminToken, "admin-token", defaults.AdminToken, "Bearer token for the admin API (empty disables it)")
+	fs.StringVar(&flagCfg.AdminTLSPort, "admin-tls-port", defaults.AdminTLSPort, "Port of a TLS listener serving only the admin API (empty disables it)")
+	fs.StringVar(&flagCfg.AdminTLSCertPath, "admin-tls-cert-path", defaults.AdminTLSCertPath, "Certificate of the admin TLS listener")
+	fs.StringVar(&flagCfg.AdminTLSKeyPath, "admin-tls-key-path", defaults.AdminTLSKeyPath, "Private key of the admin TLS listener")
+	fs.StringVar(&flagCfg.AdminClientCAPath, "admin-client-ca-path", defaults.AdminClientCAPath, "CA whose client certificates authenticate admins on the admin TLS listener")
+	fs.IntVar(&flagCfg.MaxNames, "max-names", defaults.MaxNames, "Names a device can hold unless its plan allows otherwise")
+	fs.StringVar(&flagCfg.IdentityVerifiers, "identity-verifiers", defaults.IdentityVerifiers, "Comma separated verifiers of device UUIDs, asked in order (remote, allowlist, database)")
+	fs.StringVar(&flagCfg.IdentityURL, "identity-url", defaults.IdentityURL, "URL the remote verifier asks about a UUID, {uuid} is replaced by it")
+	fs.StringVar(&flagCfg.IdentityField, "identity-field", defaults.IdentityField, "JSON field (may be nested, like data.valid) that is true in answers accepting a UUID")
+	fs.IntVar(&flagCfg.IdentityTimeout, "identity-timeout", defaults.IdentityTimeout, "Seconds to wait for the remote verifier")
+	fs.StringVar(&flagCfg.IdentityAllowlistPath, "identity-allowlist-path", defaults.IdentityAllowlistPath, "File of UUIDs the allowlist verifier accepts, one per line")
+	fs.IntVar(&flagCfg.IdentityCacheTTL, "identity-cache-ttl", defaults.IdentityCacheTTL, "Seconds UUIDs accepted by the remote verifier are cached (0 disables caching)")
+	fs.IntVar(&flagCfg.IdentityNegativeCacheTTL, "identity-negative-cache-ttl", defaults.IdentityNegativeCacheTTL, "Seconds UUIDs rejected by the remote verifier are cached (0 disables caching)")
+	fs.IntVar(&flagCfg.IdentityBreakerFailures, "identity-breaker-failures", defaults.IdentityBreakerFailures, "Failures of the remote verifier in a row after which it isn't asked for a while (0 always asks)")
+	fs.IntVar(&flagCfg.IdentityBreakerCooldown, "identity-breaker-cooldown", defaults.IdentityBreakerCooldown, "Seconds the remote verifier isn't asked after failing repeatedly")
+	fs.BoolVar(&flagCfg.IdentityFailOpen, "identity-fail-open", defaults.IdentityFailOpen, "Accept UUIDs the remote verifier can't be asked about instead of failing the request")
+	fs.BoolVar(&flagCfg.LegacyUUIDAuth, "legacy-uuid-auth", defaults.LegacyUUIDAuth, "Authenticate device requests without a bearer token by their uuid query parameter")
+	fs.IntVar(&flagCfg.SignatureMaxSkew, "signature-max-skew", defaults.SignatureMaxSkew, "Seconds the timestamp of a signed request may differ from the server clock")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %s", fs.Arg(0))
+	}
 
-	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, logger.AsciiArt)
-		fmt.Fprintf(os.Stderr, "Configuration options:\n")
-		flag.PrintDefaults()
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			setFlags[strings.ReplaceAll(f.Name, "-", "_")] = true
+		}
+	})
+
+	r := &Reloadable{
+		path: *configPath,
+		load: func() (*Config, error) {
+			cfg := defaultConfig()
+
+			if *configPath != "" {
+				if err := loadFile(*configPath, &cfg); err != nil {
+					return nil, err
+				}
+			}
+
+			envErr := applyEnv(&cfg, os.Environ())
+			applyFlags(&cfg, &flagCfg, setFlags)
+
+			if err := errors.Join(envErr, cfg.Validate()); err != nil {
+				return nil, err
+			}
+
+			return &cfg, nil
+		},
+	}
+
+	cfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.current.Store(cfg)
+
+	return r, nil
+}
+
+// Redacted returns a copy of the config with tokens and secrets masked, for
+// showing it.
+func (cfg Config) Redacted() Config {
+	mask := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return "********"
 	}
 
-	flag.StringVar(&cfg.DBPath, "db-path", "./data/ddns.db", "Database path")
-	flag.StringVar(&cfg.LogPath, "log-path", "./data/dddns.log", "Log file path")
-	flag.StringVar(&cfg.HTTPPort, "dns-addr", "::", "DNS server bind address")
-	flag.StringVar(&cfg.DNSPort, "dns-port", "5544", "DNS server port")
-	flag.StringVar(&cfg.HTTPPort, "http-addr", "::", "HTTP server bind address")
-	flag.StringVar(&cfg.HTTPPort, "http-port", "3000", "HTTP server port")
-	flag.StringVar(&cfg.Domain, "domain", "difusedns.com", "Domain to use for DNS records")
-	flag.StringVar(&cfg.NameServerDomain, "name-server-domain", "ns1.difuse.io", "Domain to use for name server records")
-	flag.StringVar(&cfg.MailBox, "mail-box", "admin.difusedns.com", "Mail box to use for SOA records")
-	flag.BoolVar(&cfg.Authoritative, "authoritative", true, "Whether or not to be authoritative for the domain")
-	flag.IntVar(&cfg.LogLevel, "log-level", 0, "Log level (0-1)")
-	flag.StringVar(&cfg.TunnelARecord, "tunnel-a-record", "0.0.0.0", "A record to use for tunneling")
-	flag.StringVar(&cfg.TunnelAAAARecord, "tunnel-aaaa-record", "::", "AAAA record to use for tunneling")
-
-	flag.Parse()
-
-	if *configPath != "" {
-		err := loadConfigFromJSON(*configPath, &cfg)
-		if err != nil {
-			logger.Log.Fatal("Error loading config from JSON", err)
+	if dsn, err := url.Parse(cfg.DBDSN); err == nil {
+		cfg.DBDSN = dsn.Redacted()
+	} else {
+		cfg.DBDSN = mask(cfg.DBDSN)
+	}
+
+	cfg.CookieSecret = mask(cfg.CookieSecret)
+	cfg.TunnelToken = mask(cfg.TunnelToken)
+	cfg.AdminToken = mask(cfg.AdminToken)
+
+	if cfg.TSIGKeys != nil {
+		keys := make(map[string]string)
+		for name, secret := range cfg.TSIGKeys {
+			keys[name] = mask(secret)
 		}
+		cfg.TSIGKeys = keys
 	}
 
 	return cfg
 }
 
-func loadConfigFromJSON(filePath string, config *Config) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
+// GetIdentityVerifiers returns the names of the identity verifiers in the
+// order they are asked.
+func (cfg *Config) GetIdentityVerifiers() []string {
+	var names []string
+
+	for _, name := range strings.Split(cfg.IdentityVerifiers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
 	}
 
-	defer file.Close()
+	return names
+}
+
+// NameQuota returns how many names a device on plan can hold.
+func (cfg *Config) NameQuota(plan string) int {
+	if p, ok := cfg.Plans[plan]; ok && plan != "" {
+		return p.MaxNames
+	}
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(config)
+	return cfg.MaxNames
 }
 
 func GetVersion() string {
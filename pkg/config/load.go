@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "DDDNS_"
+
+// loadFile decodes a JSON, YAML or TOML config file, told apart by its
+// extension, into cfg. All formats use the JSON keys, and keys without a
+// setting are an error so typos don't go unnoticed.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+
+	ext := strings.ToLower(filepath.Ext(path))
+
+	switch ext {
+	case ".json":
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("%s: unknown config file format, expected .json, .yaml, .yml or .toml", path)
+	}
+
+	// YAML and TOML are decoded through JSON, an empty document like {}.
+	if ext != ".json" {
+		if doc == nil {
+			doc = map[string]interface{}{}
+		}
+
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv sets the settings given as DDDNS_<KEY> variables in environ, like
+// DDDNS_DNS_PORT=53. Lists and maps are given as JSON.
+func applyEnv(cfg *Config, environ []string) error {
+	fields := settingFields(cfg)
+
+	var names []string
+	values := make(map[string]string)
+
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, envPrefix) {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		field, ok := fields[strings.ToLower(strings.TrimPrefix(name, envPrefix))]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", name))
+			continue
+		}
+
+		if err := setValue(field, values[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyFlags copies the settings given on the command line from flagCfg.
+func applyFlags(cfg *Config, flagCfg *Config, set map[string]bool) {
+	fields := settingFields(cfg)
+
+	for key, field := range settingFields(flagCfg) {
+		if set[key] {
+			fields[key].Set(field)
+		}
+	}
+}
+
+// settingFields returns the fields of cfg by their setting key.
+func settingFields(cfg *Config) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		fields[settingKey(value.Type().Field(i))] = value.Field(i)
+	}
+
+	return fields
+}
+
+// settingKey is the name of a setting in config files, which the names of
+// flags and environment variables are derived from.
+func settingKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return key
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(int64(n))
+	default:
+		field.Set(reflect.Zero(field.Type()))
+		if err := json.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+		// wantPort is the DNS port after loading, "5544" when unchanged
+		wantPort string
+	}{
+		{name: "json", file: "config.json", content: `{"dns_port": "53"}`, wantPort: "53"},
+		{name: "yaml", file: "config.yaml", content: "dns_port: \"53\"\n", wantPort: "53"},
+		{name: "yml", file: "config.yml", content: "dns_port: \"53\"\n", wantPort: "53"},
+		{name: "toml", file: "config.toml", content: "dns_port = \"53\"\n", wantPort: "53"},
+		{name: "empty yaml", file: "config.yaml", content: "", wantPort: "5544"},
+		{name: "yaml comments only", file: "config.yaml", content: "# nothing set\n", wantPort: "5544"},
+		{name: "empty toml", file: "config.toml", content: "", wantPort: "5544"},
+		{name: "empty json", file: "config.json", content: "", wantErr: true},
+		{name: "unknown key", file: "config.yaml", content: "dns_prot: \"53\"\n", wantErr: true},
+		{name: "json in yaml file", file: "config.yaml", content: `{"dns_port": "53"}`, wantPort: "53"},
+		{name: "yaml in json file", file: "config.json", content: "dns_port: \"53\"\n", wantErr: true},
+		{name: "unknown extension", file: "config.conf", content: `{"dns_port": "53"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := defaultConfig()
+			err := loadFile(path, &cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadFile() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && cfg.DNSPort != tt.wantPort {
+				t.Errorf("dns_port = %q, want %q", cfg.DNSPort, tt.wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"github.com/DifuseHQ/dddns/pkg/logger"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// restartSettings only take effect on startup: they set up listeners, files
+// and key material.
+var restartSettings = map[string]bool{
+	"db_path":              true,
+	"db_dsn":               true,
+	"auto_migrate":         true,
+	"log_path":             true,
+	"dns_addr":             true,
+	"dns_port":             true,
+	"http_addr":            true,
+	"http_port":            true,
+	"admin_tls_port":       true,
+	"admin_tls_cert_path":  true,
+	"admin_tls_key_path":   true,
+	"admin_client_ca_path": true,
+	"tsig_keys":            true,
+	"cookies":              true,
+	"cookie_secret":        true,
+	"cookie_rotation":      true,
+	"shutdown_timeout":     true,
+}
+
+// Reloadable holds the current config and replaces it when the config file is
+// read again. The config returned by Get is shared and must not be modified.
+type Reloadable struct {
+	mu      sync.Mutex
+	path    string
+	load    func() (*Config, error)
+	current atomic.Pointer[Config]
+	hooks   []func(old *Config, next *Config) error
+}
+
+// Get returns the current config.
+func (r *Reloadable) Get() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to apply a new config before it becomes current.
+// Hooks run in the order they were added and the first error aborts the
+// reload, so hooks that can fail go first and must not apply anything when
+// they do.
+func (r *Reloadable) OnReload(fn func(old *Config, next *Config) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, fn)
+}
+
+// Reload builds the config again from the config file, the environment and
+// the command line, and makes it current once every hook applied it. It returns the changed
+// settings that need a restart, which keep their old values until then.
+func (r *Reloadable) Reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	restart, err := r.reload()
+	if err != nil {
+		logger.Log.Error("Config reload failed: ", err)
+		return nil, err
+	}
+
+	logger.Log.Info("Config reloaded from ", r.path)
+
+	for _, name := range restart {
+		logger.Log.Warn("Config setting ", name, " changed, restart to apply it")
+	}
+
+	return restart, nil
+}
+
+func (r *Reloadable) reload() ([]string, error) {
+	if r.path == "" {
+		return nil, fmt.Errorf("no config file given")
+	}
+
+	next, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	old := r.current.Load()
+	restart := keepRestartSettings(old, next)
+
+	for _, hook := range r.hooks {
+		if err := hook(old, next); err != nil {
+			return nil, err
+		}
+	}
+
+	r.current.Store(next)
+
+	return restart, nil
+}
+
+// keepRestartSettings resets the settings of next that can't change live to
+// their old values and returns the names of those that differed.
+func keepRestartSettings(old *Config, next *Config) []string {
+	restart := []string{}
+
+	oldValue := reflect.ValueOf(old).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := settingKey(oldValue.Type().Field(i))
+		if !restartSettings[name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			restart = append(restart, name)
+			nextValue.Field(i).Set(oldValue.Field(i))
+		}
+	}
+
+	return restart
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Validate checks every setting and returns all problems found at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if cfg.DBDSN != "" && !strings.HasPrefix(cfg.DBDSN, "postgres://") && !strings.HasPrefix(cfg.DBDSN, "postgresql://") {
+		check("db_dsn", fmt.Errorf("expected a postgres:// URL"))
+	}
+
+	check("dns_addr", validateBindAddress(cfg.DNSAddr))
+	check("dns_port", validatePort(cfg.DNSPort))
+	check("http_addr", validateBindAddress(cfg.HTTPAddr))
+	check("http_port", validatePort(cfg.HTTPPort))
+	check("domain", validateDomain(cfg.Domain))
+
+	if cfg.AdminTLSPort != "" {
+		check("admin_tls_port", validatePort(cfg.AdminTLSPort))
+
+		if cfg.AdminTLSCertPath == "" || cfg.AdminTLSKeyPath == "" {
+			check("admin_tls_port", fmt.Errorf("needs admin_tls_cert_path and admin_tls_key_path"))
+		}
+	}
+	check("mail_box", validateDomain(cfg.MailBox))
+
+	if cfg.NameServerDomain != "" {
+		check("name_server_domain", validateDomain(cfg.NameServerDomain))
+	}
+
+	for _, err := range validateNameServers(cfg.NameServers) {
+		check("name_servers", err)
+	}
+	check("tunnel_a_record", validateIP(cfg.TunnelARecord, 4))
+	check("tunnel_aaaa_record", validateIP(cfg.TunnelAAAARecord, 6))
+
+	if cfg.LogLevel < 0 || cfg.LogLevel > 1 {
+		check("log_level", fmt.Errorf("must be 0 or 1"))
+	}
+
+	if cfg.RecordTTL <= 0 {
+		check("record_ttl", fmt.Errorf("must be positive"))
+	}
+
+	if cfg.RRLRate < 0 {
+		check("rrl_responses_per_second", fmt.Errorf("can't be negative"))
+	}
+
+	if cfg.RRLSlip < 0 {
+		check("rrl_slip", fmt.Errorf("can't be negative"))
+	}
+
+	if cfg.CookieRotation <= 0 {
+		check("cookie_rotation", fmt.Errorf("must be positive"))
+	}
+
+	if cfg.ShutdownTimeout < 0 {
+		check("shutdown_timeout", fmt.Errorf("can't be negative"))
+	}
+
+	if cfg.MaxNames < 1 {
+		check("max_names", fmt.Errorf("must be at least 1"))
+	}
+
+	for name, plan := range cfg.Plans {
+		if plan.MaxNames < 1 {
+			check(fmt.Sprintf("plans.%s.max_names", name), fmt.Errorf("must be at least 1"))
+		}
+	}
+
+	verifiers := cfg.GetIdentityVerifiers()
+	if len(verifiers) == 0 {
+		check("identity_verifiers", fmt.Errorf("at least one verifier is needed"))
+	}
+
+	for _, name := range verifiers {
+		switch name {
+		case "remote":
+			target, err := url.Parse(cfg.IdentityURL)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				check("identity_url", fmt.Errorf("expected an http:// or https:// URL"))
+			} else if !strings.Contains(cfg.IdentityURL, "{uuid}") {
+				check("identity_url", fmt.Errorf("missing {uuid} placeholder"))
+			}
+
+			if cfg.IdentityField == "" {
+				check("identity_field", fmt.Errorf("can't be empty"))
+			}
+
+			if cfg.IdentityTimeout <= 0 {
+				check("identity_timeout", fmt.Errorf("must be positive"))
+			}
+
+			if cfg.IdentityCacheTTL < 0 {
+				check("identity_cache_ttl", fmt.Errorf("can't be negative"))
+			}
+
+			if cfg.IdentityNegativeCacheTTL < 0 {
+				check("identity_negative_cache_ttl", fmt.Errorf("can't be negative"))
+			}
+
+			if cfg.IdentityBreakerFailures < 0 {
+				check("identity_breaker_failures", fmt.Errorf("can't be negative"))
+			}
+
+			if cfg.IdentityBreakerCooldown <= 0 {
+				check("identity_breaker_cooldown", fmt.Errorf("must be positive"))
+			}
+		case "allowlist":
+			if cfg.IdentityAllowlistPath == "" {
+				check("identity_allowlist_path", fmt.Errorf("needed by the allowlist verifier"))
+			}
+		case "database":
+		default:
+			check("identity_verifiers", fmt.Errorf("unknown verifier %s", name))
+		}
+	}
+
+	if cfg.SignatureMaxSkew <= 0 {
+		check("signature_max_skew", fmt.Errorf("must be positive"))
+	}
+
+	if cfg.CookieSecret != "" {
+		secret, err := hex.DecodeString(cfg.CookieSecret)
+		if err != nil {
+			check("cookie_secret", fmt.Errorf("not hex encoded"))
+		} else if len(secret) < 16 {
+			check("cookie_secret", fmt.Errorf("must be at least 16 bytes"))
+		}
+	}
+
+	if cfg.AliasUpstream != "" {
+		if _, port, err := net.SplitHostPort(cfg.AliasUpstream); err != nil {
+			check("alias_upstream", fmt.Errorf("expected host:port"))
+		} else {
+			check("alias_upstream", validatePort(port))
+		}
+	}
+
+	for name, regions := range cfg.GeoRecords {
+		for region, set := range regions {
+			for _, address := range set.IPv4 {
+				check(fmt.Sprintf("geo_records.%s.%s", name, region), validateIP(address, 4))
+			}
+			for _, address := range set.IPv6 {
+				check(fmt.Sprintf("geo_records.%s.%s", name, region), validateIP(address, 6))
+			}
+		}
+	}
+
+	for i, view := range cfg.Views {
+		key := fmt.Sprintf("views[%d]", i)
+
+		if view.Name == "" {
+			check(key, fmt.Errorf("missing name"))
+		}
+
+		for _, cidr := range view.Networks {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				check(key, fmt.Errorf("invalid network %s", cidr))
+			}
+		}
+
+		for _, tsigKey := range view.TSIGKeys {
+			if !cfg.hasTSIGKey(tsigKey) {
+				check(key, fmt.Errorf("unknown TSIG key %s", tsigKey))
+			}
+		}
+	}
+
+	domains := make(map[string]bool)
+
+	for i, zone := range cfg.Zones {
+		key := fmt.Sprintf("zones[%d]", i)
+
+		if err := validateDomain(zone.Domain); err != nil {
+			check(key+".domain", err)
+			continue
+		}
+
+		domain := strings.ToLower(strings.TrimSuffix(zone.Domain, "."))
+		if domains[domain] {
+			check(key+".domain", fmt.Errorf("zone %s is configured twice", domain))
+		}
+		domains[domain] = true
+
+		if zone.MailBox != "" {
+			check(key+".mail_box", validateDomain(zone.MailBox))
+		}
+
+		if zone.NameServerDomain != "" {
+			check(key+".name_server_domain", validateDomain(zone.NameServerDomain))
+		}
+
+		for _, err := range validateNameServers(zone.NameServers) {
+			check(key+".name_servers", err)
+		}
+		check(key+".tunnel_a_record", validateIP(zone.TunnelARecord, 4))
+		check(key+".tunnel_aaaa_record", validateIP(zone.TunnelAAAARecord, 6))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) hasTSIGKey(name string) bool {
+	for key := range cfg.TSIGKeys {
+		if strings.EqualFold(strings.TrimSuffix(key, "."), strings.TrimSuffix(name, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateBindAddress accepts an IP address, or empty for all addresses.
+func validateBindAddress(address string) error {
+	if address != "" && net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid IP address %q", address)
+	}
+
+	return nil
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+// validateIP accepts an address of the given IP version, or empty.
+func validateIP(address string, version int) error {
+	if address == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil || (version == 4) != (ip.To4() != nil) {
+		return fmt.Errorf("invalid IPv%d address %q", version, address)
+	}
+
+	return nil
+}
+
+// validateDomain checks name is a domain name of valid hostname labels, with
+// or without the trailing dot.
+func validateDomain(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+
+	if trimmed == "" || len(trimmed) > 253 {
+		return fmt.Errorf("invalid domain name %q", name)
+	}
+
+	for _, label := range strings.Split(trimmed, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid domain name %q", name)
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid domain name %q", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateNameServers(nameServers []NameServer) []error {
+	var errs []error
+
+	for _, ns := range nameServers {
+		if err := validateDomain(ns.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := validateIP(ns.IPv4, 4); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ns.Name, err))
+		}
+
+		if err := validateIP(ns.IPv6, 6); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ns.Name, err))
+		}
+	}
+
+	return errs
+}
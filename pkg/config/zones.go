@@ -0,0 +1,152 @@
+package config
+
+import (
+	"strings"
+)
+
+// Zone holds the settings of one base domain dddns is authoritative for.
+type Zone struct {
+	Domain             string       `json:"domain"`
+	NameServerDomain   string       `json:"name_server_domain"`
+	NameServers        []NameServer `json:"name_servers"`
+	MailBox            string       `json:"mail_box"`
+	SOARefresh         uint32       `json:"soa_refresh"`
+	SOARetry           uint32       `json:"soa_retry"`
+	SOAExpire          uint32       `json:"soa_expire"`
+	SOAMinTTL          uint32       `json:"soa_min_ttl"`
+	Backname           bool         `json:"backname"`
+	Tunnel             bool         `json:"tunnel"`
+	TunnelARecord      string       `json:"tunnel_a_record"`
+	TunnelAAAARecord   string       `json:"tunnel_aaaa_record"`
+	RegistrationClosed bool         `json:"registration_closed"`
+	ReservedLabels     []string     `json:"reserved_labels"`
+}
+
+// NameServer is one of the nameservers of a zone. Its addresses are served as
+// glue when the name lies inside the zone.
+type NameServer struct {
+	Name string `json:"name"`
+	IPv4 string `json:"ipv4"`
+	IPv6 string `json:"ipv6"`
+}
+
+// GetZones returns every zone served. The top level domain settings form the
+// primary zone, which always comes first; an entry in zones for the same
+// domain replaces it.
+func (c Config) GetZones() []Zone {
+	primary := Zone{
+		Domain:           c.Domain,
+		NameServerDomain: c.NameServerDomain,
+		NameServers:      c.NameServers,
+		MailBox:          c.MailBox,
+		Backname:         true,
+		Tunnel:           true,
+		TunnelARecord:    c.TunnelARecord,
+		TunnelAAAARecord: c.TunnelAAAARecord,
+	}
+
+	var others []Zone
+	for _, zone := range c.Zones {
+		if strings.EqualFold(strings.TrimSuffix(zone.Domain, "."), primary.Domain) {
+			primary = zone
+			continue
+		}
+		others = append(others, zone)
+	}
+
+	zones := append([]Zone{primary}, others...)
+	for i := range zones {
+		zones[i].applyDefaults()
+	}
+
+	return zones
+}
+
+// GetZone returns the zone with exactly the given domain.
+func (c Config) GetZone(domain string) *Zone {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	for _, zone := range c.GetZones() {
+		if zone.Domain == domain {
+			return &zone
+		}
+	}
+
+	return nil
+}
+
+// FindZone returns the most specific zone a name falls in, or nil.
+func (c Config) FindZone(name string) *Zone {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	var found *Zone
+	for _, zone := range c.GetZones() {
+		if zone.Contains(name) && (found == nil || len(zone.Domain) > len(found.Domain)) {
+			z := zone
+			found = &z
+		}
+	}
+
+	return found
+}
+
+// Contains reports whether name is the zone apex or a name below it.
+func (z Zone) Contains(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	return name == z.Domain || strings.HasSuffix(name, "."+z.Domain)
+}
+
+// IsReserved reports whether name is one of the zone's nameservers or the
+// label directly below the apex of name is one the zone doesn't hand out.
+func (z Zone) IsReserved(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	labels := strings.Split(strings.TrimSuffix(name, "."+z.Domain), ".")
+	label := labels[len(labels)-1]
+
+	if (z.Backname && label == "backname") || (z.Tunnel && label == "tunnel") {
+		return true
+	}
+
+	for _, ns := range z.NameServers {
+		if strings.EqualFold(strings.TrimSuffix(ns.Name, "."), name) {
+			return true
+		}
+	}
+
+	for _, reserved := range z.ReservedLabels {
+		if strings.EqualFold(reserved, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (z *Zone) applyDefaults() {
+	z.Domain = strings.ToLower(strings.TrimSuffix(z.Domain, "."))
+
+	if len(z.NameServers) == 0 && z.NameServerDomain != "" {
+		z.NameServers = []NameServer{{Name: z.NameServerDomain}}
+	}
+
+	if len(z.NameServers) == 0 {
+		z.NameServers = []NameServer{{Name: "ns1." + z.Domain}}
+	}
+
+	if z.SOARefresh == 0 {
+		z.SOARefresh = 3600
+	}
+
+	if z.SOARetry == 0 {
+		z.SOARetry = 600
+	}
+
+	if z.SOAExpire == 0 {
+		z.SOAExpire = 1209600
+	}
+
+	if z.SOAMinTTL == 0 {
+		z.SOAMinTTL = 300
+	}
+}
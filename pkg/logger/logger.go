@@ -9,6 +9,8 @@ import (
 
 var Log *logrus.Logger
 
+var logFile *os.File
+
 const (
 	AsciiArt = `
 ██████╗ ██████╗ ██████╗ ███╗   ██╗███████╗
@@ -21,10 +23,35 @@ const (
 
 func InitLogger(logPath string, logLevel int, version string) {
 	fmt.Println(AsciiArt)
-	fmt.Println("\t\t\t\t", version, "\n")
+	fmt.Print("\t\t\t\t ", version, "\n\n")
 
 	Log = logrus.New()
+	SetLevel(logLevel)
+
+	var err error
+	logFile, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		Log.Fatal("Unable to open log file for writing: ", err.Error())
+	}
+
+	formatter := &logrus.TextFormatter{
+		ForceColors:   true,
+		FullTimestamp: true,
+	}
+
+	Log.SetFormatter(formatter)
+	Log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+}
 
+// InitConsoleLogger logs to stderr only, for the command line tools.
+func InitConsoleLogger(logLevel int) {
+	Log = logrus.New()
+	Log.SetOutput(os.Stderr)
+	SetLevel(logLevel)
+}
+
+// SetLevel switches between info (0) and debug (1) logging.
+func SetLevel(logLevel int) {
 	switch logLevel {
 	case 0:
 		Log.SetLevel(logrus.InfoLevel)
@@ -33,17 +60,23 @@ func InitLogger(logPath string, logLevel int, version string) {
 	default:
 		Log.SetLevel(logrus.InfoLevel)
 	}
+}
 
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		Log.Fatal("Unable to open log file for writing: ", err.Error())
+// Close flushes and closes the log file. Anything logged afterwards only goes
+// to stdout.
+func Close() error {
+	if logFile == nil {
+		return nil
 	}
 
-	formatter := &logrus.TextFormatter{
-		ForceColors:   true,
-		FullTimestamp: true,
+	Log.SetOutput(os.Stdout)
+
+	if err := logFile.Sync(); err != nil {
+		return err
 	}
 
-	Log.SetFormatter(formatter)
-	Log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	err := logFile.Close()
+	logFile = nil
+
+	return err
 }